@@ -9,8 +9,9 @@ import (
 )
 
 func dataGitopsMetadataCluster() *schema.Resource {
-	return &schema.Resource{
-		ReadContext: dataGitopsMetadataClusterRead,
+	return withResourceDiagnostics(&schema.Resource{
+		ReadWithoutTimeout: withGitopsTimeout(schema.TimeoutRead, dataGitopsMetadataClusterRead),
+		Timeouts:           gitopsDataSourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"server_name": {
 				Type:     schema.TypeString,
@@ -32,42 +33,42 @@ func dataGitopsMetadataCluster() *schema.Resource {
 				Required: true,
 			},
 			"default_ingress_subdomain": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The default ingress subdomain for the cluster used to build ingress/route host names",
 			},
 			"default_ingress_secret": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The name of the secret in the cluster that holds the TLS information used to create secured ingresses",
 			},
 			"cluster_type": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The type of cluster. Values will be 'ocp4' or 'kubernetes'",
 			},
 			"kube_version": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The kubernetes version of the cluster",
 			},
 			"openshift_version": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The OpenShift version of the cluster. If the cluster is not an OpenShift cluster this value will be empty",
 			},
 			"operator_namespace": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The namespace where the cluster-wide operators are installed in the cluster",
 			},
 			"gitops_namespace": {
-				Type: schema.TypeString,
-				Computed: true,
+				Type:        schema.TypeString,
+				Computed:    true,
 				Description: "The namespace where the gitops instance is installed in the cluster",
 			},
 		},
-	}
+	})
 }
 
 func dataGitopsMetadataClusterRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -75,20 +76,18 @@ func dataGitopsMetadataClusterRead(ctx context.Context, d *schema.ResourceData,
 
 	config := m.(*ProviderConfig)
 
-	binDir := config.BinDir
-
 	metadataConfig := GitopsMetadataConfig{
-		Branch:         getBranchInput(d),
-		ServerName:     getServerNameInput(d),
-		Credentials:    getCredentialsInput(d),
-		Config:         getGitopsConfigInput(d),
-		CaCert:         config.GitConfig.CaCertFile,
-		Debug:          config.Debug,
+		Branch:      getBranchInput(d),
+		ServerName:  getServerNameInput(d),
+		Credentials: getCredentialsInput(d),
+		Config:      getGitopsConfigInput(d),
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
 	}
 
-	gitopsMetadata, err := readGitopsMetadata(ctx, binDir, metadataConfig)
+	gitopsMetadata, err := readGitopsMetadata(ctx, config, metadataConfig)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	err = d.Set("cluster_type", gitopsMetadata.Cluster.Type)
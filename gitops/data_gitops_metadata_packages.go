@@ -10,8 +10,9 @@ import (
 )
 
 func dataGitopsMetadataPackages() *schema.Resource {
-	return &schema.Resource{
-		ReadContext: dataGitopsMetadataPackagesRead,
+	return withResourceDiagnostics(&schema.Resource{
+		ReadWithoutTimeout: withGitopsTimeout(schema.TimeoutRead, dataGitopsMetadataPackagesRead),
+		Timeouts:           gitopsDataSourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"server_name": {
 				Type:     schema.TypeString,
@@ -33,10 +34,10 @@ func dataGitopsMetadataPackages() *schema.Resource {
 				Required: true,
 			},
 			"package_name_filter": {
-				Type:     schema.TypeList,
-				Optional: true,
+				Type:        schema.TypeList,
+				Optional:    true,
 				Description: "List of package name filters to returned packages. The values can be regular expressions. Results will be returned in the order of the matching filters. If not provided or an empty list is provided, all packages will be returned.",
-				Elem:     &schema.Schema{Type: schema.TypeString},
+				Elem:        &schema.Schema{Type: schema.TypeString},
 			},
 			"packages": {
 				Type:     schema.TypeList,
@@ -67,7 +68,7 @@ func dataGitopsMetadataPackages() *schema.Resource {
 				},
 			},
 		},
-	}
+	})
 }
 
 func dataGitopsMetadataPackagesRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -75,22 +76,20 @@ func dataGitopsMetadataPackagesRead(ctx context.Context, d *schema.ResourceData,
 
 	config := m.(*ProviderConfig)
 
-	binDir := config.BinDir
-
 	metadataConfig := GitopsMetadataConfig{
-		Branch:         getBranchInput(d),
-		ServerName:     getServerNameInput(d),
-		Credentials:    getCredentialsInput(d),
-		Config:         getGitopsConfigInput(d),
-		CaCert:         config.GitConfig.CaCertFile,
-		Debug:          config.Debug,
+		Branch:      getBranchInput(d),
+		ServerName:  getServerNameInput(d),
+		Credentials: getCredentialsInput(d),
+		Config:      getGitopsConfigInput(d),
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
 	}
 
 	packageFilter := getPackageNameFilterInput(d)
 
-	gitopsMetadata, err := readGitopsMetadata(ctx, binDir, metadataConfig)
+	gitopsMetadata, err := readGitopsMetadata(ctx, config, metadataConfig)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	packages := filterPackages(&gitopsMetadata.Packages, packageFilter)
@@ -146,4 +145,4 @@ func flattenPackageData(packages *[]GitopsMetadataPackage) []interface{} {
 	}
 
 	return make([]interface{}, 0)
-}
\ No newline at end of file
+}
@@ -8,16 +8,15 @@ import (
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"os"
-	"os/exec"
-	"path/filepath"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
 func dataGitopsRepoConfig() *schema.Resource {
-	return &schema.Resource{
-		ReadContext: dataGitopsRepoConfigRead,
+	return withResourceDiagnostics(&schema.Resource{
+		ReadWithoutTimeout: withGitopsTimeout(schema.TimeoutRead, dataGitopsRepoConfigRead),
+		Timeouts:           gitopsDataSourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"server_name": {
 				Type:     schema.TypeString,
@@ -70,7 +69,7 @@ func dataGitopsRepoConfig() *schema.Resource {
 				Sensitive:   true,
 			},
 		},
-	}
+	})
 }
 
 type GitopsRepoReadConfig struct {
@@ -82,7 +81,6 @@ type GitopsRepoReadConfig struct {
 	CaCert       string
 	CaCertFile   string
 	Debug        string
-	BinDir       string
 }
 
 func dataGitopsRepoConfigRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -90,8 +88,6 @@ func dataGitopsRepoConfigRead(ctx context.Context, d *schema.ResourceData, m int
 
 	config := m.(*ProviderConfig)
 
-	binDir := config.BinDir
-
 	repoReadConfig := GitopsRepoReadConfig{
 		ServerName:   getServerNameInput(d),
 		Branch:       getBranchInput(d),
@@ -100,13 +96,12 @@ func dataGitopsRepoConfigRead(ctx context.Context, d *schema.ResourceData, m int
 		Token:        d.Get("token").(string),
 		CaCert:       d.Get("ca_cert").(string),
 		CaCertFile:   d.Get("ca_cert_file").(string),
-		BinDir:       binDir,
 		Debug:        config.Debug,
 	}
 
-	result, err := lookupGitopRepoConfig(ctx, &repoReadConfig)
+	result, err := lookupGitopRepoConfig(ctx, config, &repoReadConfig)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	gitopsConfigJson, err := toJson(result)
@@ -139,14 +134,41 @@ func dataGitopsRepoConfigRead(ctx context.Context, d *schema.ResourceData, m int
 	return diags
 }
 
-func lookupGitopRepoConfig(ctx context.Context, input *GitopsRepoReadConfig) (*GitopsConfigResult, error) {
+func lookupGitopRepoConfig(ctx context.Context, config *ProviderConfig, input *GitopsRepoReadConfig) (*GitopsConfigResult, error) {
+
+	lockKey := gitRepoLockKey(input.BootstrapUrl, input.Branch)
+
+	unlock, err := lockGitRepo(ctx, lockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	if config.Engine != "native" {
+		return lookupGitopRepoConfigIgc(ctx, config.Executor, input)
+	}
 
-	// this should be replaced with the actual git user
-	username := "cloudnativetoolkit"
+	var result *GitopsConfigResult
+	err = retryTransient(ctx, config.Retry, "gitops-config", func() error {
+		var nativeErr error
+		result, nativeErr = lookupGitopRepoConfigNative(ctx, input)
 
-	gitopsMutexKV.Lock(username)
+		return nativeErr
+	})
+	if err != nil && ctx.Err() != nil {
+		err = commandContextError(ctx, "gitops-config")
+	}
+
+	return result, err
+}
 
-	defer gitopsMutexKV.Unlock(username)
+func lookupGitopRepoConfigIgc(ctx context.Context, executor Executor, input *GitopsRepoReadConfig) (*GitopsConfigResult, error) {
+	outputFile, cleanup, err := commandOutputFile("gitops-config")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 
 	tflog.Info(ctx, fmt.Sprintf("Retrieving gitops metadata: serverName=%s", input.ServerName))
 
@@ -155,7 +177,7 @@ func lookupGitopRepoConfig(ctx context.Context, input *GitopsRepoReadConfig) (*G
 		input.BootstrapUrl,
 		"--branch", input.Branch,
 		"--serverName", input.ServerName,
-		"--output", "jsonfile=./output.json"}
+		"--output", "jsonfile=" + outputFile}
 
 	if len(input.CaCert) > 0 {
 		args = append(args, "--caCert", input.CaCert)
@@ -164,58 +186,34 @@ func lookupGitopRepoConfig(ctx context.Context, input *GitopsRepoReadConfig) (*G
 		args = append(args, "--debug", input.Debug)
 	}
 
-	cmd := exec.Command(filepath.Join(input.BinDir, "igc"), args...)
-
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
-
-	updatedEnv := append(os.Environ(), "GIT_USERNAME="+input.Username)
-	updatedEnv = append(updatedEnv, "GIT_TOKEN="+input.Token)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
-
-	logEnvironment(ctx, &updatedEnv)
+	env := append([]string{
+		"GIT_USERNAME=" + input.Username,
+		"GIT_TOKEN=" + input.Token,
+	}, gitCommitterEnv()...)
 
-	cmd.Env = updatedEnv
+	var outb bytes.Buffer
 
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error starting command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
-		return nil, err
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
+	err = executor.Execute(ctx, CommandRequest{
+		Name:   "igc",
+		Args:   args,
+		Env:    env,
+		Stdout: &outb,
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	outText := outb.String()
 	if len(outText) > 0 {
-		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", outText))
-	}
-
-	errText := errb.String()
-	if len(errText) > 0 {
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errText))
+		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", redact(outText)))
 	}
 
-	dat, err := os.ReadFile("./output.json")
+	dat, err := os.ReadFile(outputFile)
 	if err != nil {
 		return nil, err
 	}
 
-	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops config: %s", string(dat)))
+	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops config: %s", redact(string(dat))))
 
 	gitopsConfig := GitopsConfigResult{}
 	err = json.Unmarshal(dat, &gitopsConfig)
@@ -223,7 +221,7 @@ func lookupGitopRepoConfig(ctx context.Context, input *GitopsRepoReadConfig) (*G
 		return nil, err
 	}
 
-	tflog.Debug(ctx,"Result values from gitops config")
+	tflog.Debug(ctx, "Result values from gitops config")
 
 	return &gitopsConfig, nil
 }
@@ -0,0 +1,163 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+)
+
+// knownError maps the messages of a common failure, matched in lower case against the error and the
+// stderr of the command, to a hint and the attribute that most likely needs to change
+type knownError struct {
+	messages  []string
+	hint      string
+	attribute string
+}
+
+var knownErrors = []knownError{
+	{
+		messages: []string{
+			"authentication failed",
+			"authentication required",
+			"bad credentials",
+			"invalid username or password",
+			"could not read username",
+			"returned error: 401",
+			"returned error: 403",
+			"status code: 401",
+			"status code: 403",
+		},
+		hint:      "The git server rejected the credentials. Check that the token is valid, has not expired and has write access to the repo.",
+		attribute: "credentials",
+	},
+	{
+		messages: []string{
+			"couldn't find remote ref",
+			"remote branch",
+			"reference not found",
+			"not a valid branch",
+		},
+		hint:      "The branch was not found in the gitops repo. Check the branch name or create the branch first.",
+		attribute: "branch",
+	},
+	{
+		messages: []string{
+			"cannot fetch certificate",
+			"error fetching certificate",
+			"failed to read the kubeseal cert",
+			"failed to parse certificate",
+			"no certificate",
+		},
+		hint:      "The certificate could not be loaded. Provide the PEM encoded public certificate of the sealed-secrets controller in kubeseal_cert, e.g. the output of kubeseal --fetch-cert.",
+		attribute: "kubeseal_cert",
+	},
+	{
+		messages: []string{
+			"unknown gitops layer",
+			"unknown layer",
+			"invalid layer",
+		},
+		hint:      "The layer is not defined in the gitops config. Use one of infrastructure, services or applications.",
+		attribute: "layer",
+	},
+}
+
+// errorDiagnostics converts the error of a command or git operation into a diagnostic with the
+// sanitized command line, the last lines of stderr and, for known failures, a hint and the path of
+// the attribute to fix
+func errorDiagnostics(err error) diag.Diagnostics {
+	if err == nil {
+		return nil
+	}
+
+	diagnostic := diag.Diagnostic{
+		Severity: diag.Error,
+		Summary:  err.Error(),
+	}
+
+	messages := []string{strings.ToLower(err.Error())}
+	details := []string{}
+
+	var commandError *CommandError
+	if errors.As(err, &commandError) {
+		diagnostic.Summary = fmt.Sprintf("Error running %s", commandError.Command)
+
+		details = append(details, fmt.Sprintf("Command: %s", commandError.CommandLine))
+		details = append(details, fmt.Sprintf("Error: %v", commandError.Err))
+
+		if len(commandError.Stderr) > 0 {
+			details = append(details, "Last lines of stderr:\n"+strings.Join(commandError.Stderr, "\n"))
+		}
+
+		for _, line := range commandError.Stderr {
+			messages = append(messages, strings.ToLower(line))
+		}
+	}
+
+	if match := matchKnownError(messages); match != nil {
+		details = append(details, "Hint: "+match.hint)
+		diagnostic.AttributePath = cty.GetAttrPath(match.attribute)
+	}
+
+	diagnostic.Summary = redact(diagnostic.Summary)
+	diagnostic.Detail = redact(strings.Join(details, "\n\n"))
+
+	return diag.Diagnostics{diagnostic}
+}
+
+func matchKnownError(messages []string) *knownError {
+	for i, entry := range knownErrors {
+		for _, match := range entry.messages {
+			for _, message := range messages {
+				if strings.Contains(message, match) {
+					return &knownErrors[i]
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// withAttributeDiagnostics drops the attribute path of diagnostics that point to an attribute the
+// resource does not have, e.g. the credentials of a resource that takes a token instead
+func withAttributeDiagnostics(resourceSchema map[string]*schema.Schema, f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+	if f == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+		diags := f(ctx, d, m)
+
+		for i := range diags {
+			if len(diags[i].AttributePath) == 0 {
+				continue
+			}
+
+			step, ok := diags[i].AttributePath[0].(cty.GetAttrStep)
+			if !ok {
+				continue
+			}
+
+			if _, found := resourceSchema[step.Name]; !found {
+				diags[i].AttributePath = nil
+			}
+		}
+
+		return diags
+	}
+}
+
+// withResourceDiagnostics applies withAttributeDiagnostics to the operations of the resource
+func withResourceDiagnostics(resource *schema.Resource) *schema.Resource {
+	resource.CreateWithoutTimeout = withAttributeDiagnostics(resource.Schema, resource.CreateWithoutTimeout)
+	resource.ReadWithoutTimeout = withAttributeDiagnostics(resource.Schema, resource.ReadWithoutTimeout)
+	resource.UpdateWithoutTimeout = withAttributeDiagnostics(resource.Schema, resource.UpdateWithoutTimeout)
+	resource.DeleteWithoutTimeout = withAttributeDiagnostics(resource.Schema, resource.DeleteWithoutTimeout)
+
+	return resource
+}
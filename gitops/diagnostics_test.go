@@ -0,0 +1,99 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+	"testing"
+)
+
+func TestErrorDiagnosticsKnownErrors(t *testing.T) {
+	for _, entry := range knownErrors {
+		for _, message := range entry.messages {
+			t.Run(message, func(t *testing.T) {
+				// the message is matched in the error and in the stderr of the command, in any case
+				for _, err := range []error{
+					errors.New("gitops-module: " + strings.ToUpper(message)),
+					&CommandError{Command: "igc gitops-module", CommandLine: "igc gitops-module my-module", Err: errors.New("exit status 1"), Stderr: []string{"fatal: " + message}},
+				} {
+					diags := errorDiagnostics(err)
+
+					if len(diags) != 1 || diags[0].Severity != diag.Error {
+						t.Fatalf("expected a single error, got %v", diags)
+					}
+					if !strings.Contains(diags[0].Detail, "Hint: "+entry.hint) {
+						t.Errorf("expected the hint %q in the detail, got %q", entry.hint, diags[0].Detail)
+					}
+					if !diags[0].AttributePath.Equals(cty.GetAttrPath(entry.attribute)) {
+						t.Errorf("expected the path of %s, got %v", entry.attribute, diags[0].AttributePath)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestErrorDiagnosticsUnknownError(t *testing.T) {
+	diags := errorDiagnostics(&CommandError{
+		Command:     "igc gitops-module",
+		CommandLine: "igc gitops-module my-module --token " + redactedValue,
+		Err:         errors.New("exit status 1"),
+		Stderr:      []string{"something went wrong"},
+	})
+
+	if len(diags) != 1 || diags[0].Summary != "Error running igc gitops-module" {
+		t.Fatalf("unexpected diagnostics %v", diags)
+	}
+	if strings.Contains(diags[0].Detail, "Hint:") || len(diags[0].AttributePath) != 0 {
+		t.Errorf("expected no hint and no attribute path, got %q %v", diags[0].Detail, diags[0].AttributePath)
+	}
+	for _, expected := range []string{"Command: igc gitops-module my-module --token " + redactedValue, "Error: exit status 1", "something went wrong"} {
+		if !strings.Contains(diags[0].Detail, expected) {
+			t.Errorf("expected %q in the detail, got %q", expected, diags[0].Detail)
+		}
+	}
+
+	if errorDiagnostics(nil) != nil {
+		t.Error("expected no diagnostics without an error")
+	}
+}
+
+func TestWithAttributeDiagnostics(t *testing.T) {
+	resourceSchema := map[string]*schema.Schema{
+		"branch": {Type: schema.TypeString, Optional: true},
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected cty.Path
+	}{
+		{name: "attribute in the schema", err: errors.New("couldn't find remote ref refs/heads/dev"), expected: cty.GetAttrPath("branch")},
+		{name: "attribute missing from the schema", err: errors.New("authentication required")},
+		{name: "no attribute", err: errors.New("something went wrong")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			operation := withAttributeDiagnostics(resourceSchema, func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+				return errorDiagnostics(test.err)
+			})
+
+			diags := operation(context.Background(), nil, nil)
+
+			if len(diags) != 1 || !diags[0].AttributePath.Equals(test.expected) {
+				t.Errorf("expected the path %v, got %v", test.expected, diags)
+			}
+			if len(diags[0].Summary) == 0 {
+				t.Error("expected the diagnostic to be kept")
+			}
+		})
+	}
+
+	if withAttributeDiagnostics(resourceSchema, nil) != nil {
+		t.Error("expected a missing operation to stay nil")
+	}
+}
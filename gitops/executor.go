@@ -0,0 +1,319 @@
+package gitops
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CommandRequest describes a single invocation of one of the clis (igc, kubeseal, kubectl) found in
+// the provider bin_dir.
+type CommandRequest struct {
+	// Name of the binary in bin_dir
+	Name string
+	Args []string
+	// Env holds the variables added to the environment of the provider process
+	Env   []string
+	Stdin io.Reader
+	// Stdout receives the output of the command. When nil the output is written to the log
+	Stdout io.Writer
+	// Debug logs the output of the command at debug level instead of info
+	Debug bool
+}
+
+// command names the cli and subcommand being run, without the remaining arguments which may hold
+// secrets
+func (r CommandRequest) command() string {
+	if len(r.Args) == 0 || strings.HasPrefix(r.Args[0], "-") {
+		return r.Name
+	}
+
+	return r.Name + " " + r.Args[0]
+}
+
+// CommandError is returned by the Executor when a command exits with an error. It keeps the last
+// lines of the stderr of the command so the failure can be classified and reported.
+type CommandError struct {
+	Command string
+	// CommandLine is the full command line with the secret arguments redacted
+	CommandLine string
+	Err         error
+	Stderr      []string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Executor runs the external commands used by the resources. Every cli invocation in the provider
+// goes through the Executor held in the ProviderConfig so it can be replaced, faked or wrapped.
+type Executor interface {
+	Execute(ctx context.Context, request CommandRequest) error
+}
+
+// ExecutorFunc adapts a function to the Executor interface so wrappers can be written inline.
+type ExecutorFunc func(ctx context.Context, request CommandRequest) error
+
+func (f ExecutorFunc) Execute(ctx context.Context, request CommandRequest) error {
+	return f(ctx, request)
+}
+
+type binDirExecutor struct {
+	binDir string
+}
+
+// NewExecutor returns the Executor that runs the clis from binDir.
+func NewExecutor(binDir string) Executor {
+	return &binDirExecutor{binDir: binDir}
+}
+
+func (e *binDirExecutor) Execute(ctx context.Context, request CommandRequest) error {
+	cmd := exec.CommandContext(ctx, filepath.Join(e.binDir, request.Name), request.Args...)
+
+	// the environment is logged first so the secrets it holds are masked in the rest of the output
+	if len(request.Env) > 0 {
+		updatedEnv := append(os.Environ(), request.Env...)
+
+		logEnvironment(ctx, &updatedEnv)
+
+		cmd.Env = updatedEnv
+	}
+
+	commandLine := redactCommandLine(request.Name, request.Args)
+
+	tflog.Debug(ctx, "Executing command: "+commandLine)
+
+	cmd.Stdin = request.Stdin
+
+	var stdout io.ReadCloser
+	if request.Stdout != nil {
+		cmd.Stdout = request.Stdout
+	} else {
+		pipe, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		stdout = pipe
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	// start the command after having set up the pipe. The command is killed when ctx is cancelled or
+	// times out
+	if err := cmd.Start(); err != nil {
+		tflog.Error(ctx, fmt.Sprintf("Error starting command: %s", fmt.Sprintln(err)))
+		return err
+	}
+
+	// both streams are drained at the same time so the command never blocks on a full pipe
+	var streams sync.WaitGroup
+	var stdoutErr error
+	stderrTail := newLineTail(stderrTailLines)
+
+	if stdout != nil {
+		streams.Add(1)
+		go func() {
+			defer streams.Done()
+
+			stdoutErr = scanLines(stdout, func(line string) {
+				line = redact(line)
+
+				if request.Debug {
+					tflog.Debug(ctx, line)
+				} else {
+					tflog.Info(ctx, line)
+				}
+			})
+		}()
+	}
+
+	streams.Add(1)
+	go func() {
+		defer streams.Done()
+
+		_ = scanLines(stderr, func(line string) {
+			line = redact(line)
+
+			tflog.Error(ctx, line)
+
+			stderrTail.add(line)
+		})
+	}()
+
+	// a killed command can leave child processes holding the pipes open, so they are closed when ctx
+	// ends to release the readers
+	drained := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if stdout != nil {
+				_ = stdout.Close()
+			}
+			_ = stderr.Close()
+		case <-drained:
+		}
+	}()
+
+	// the pipes must be read to the end before calling Wait
+	streams.Wait()
+	close(drained)
+
+	if err := cmd.Wait(); err != nil {
+		if ctxErr := commandContextError(ctx, request.command()); ctxErr != nil {
+			tflog.Error(ctx, ctxErr.Error())
+			return ctxErr
+		}
+
+		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
+		return &CommandError{
+			Command:     request.command(),
+			CommandLine: commandLine,
+			Err:         err,
+			Stderr:      stderrTail.lines(),
+		}
+	}
+
+	if stdoutErr != nil {
+		tflog.Error(ctx, fmt.Sprintf("Error processing stream: %s", fmt.Sprintln(stdoutErr)))
+		return stdoutErr
+	}
+
+	return nil
+}
+
+// scanLines passes each line of the stream to handle. Lines longer than the scanner buffer stop the
+// scan, so the rest of the stream is discarded to keep the command from blocking on the pipe.
+func scanLines(stream io.Reader, handle func(line string)) error {
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	for scanner.Scan() {
+		handle(scanner.Text())
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		_, _ = io.Copy(io.Discard, stream)
+	}
+
+	return err
+}
+
+const (
+	stderrTailLines     = 40
+	stderrTailLineWidth = 1024
+	maxLineLength       = 1024 * 1024
+)
+
+// lineTail keeps the last lines written to a stream, truncated to stderrTailLineWidth
+type lineTail struct {
+	max     int
+	entries []string
+	lock    sync.Mutex
+}
+
+func newLineTail(max int) *lineTail {
+	return &lineTail{max: max}
+}
+
+func (t *lineTail) add(line string) {
+	if len(line) > stderrTailLineWidth {
+		line = line[:stderrTailLineWidth] + "..."
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.entries) == t.max {
+		copy(t.entries, t.entries[1:])
+		t.entries = t.entries[:t.max-1]
+	}
+
+	t.entries = append(t.entries, line)
+}
+
+func (t *lineTail) lines() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	result := make([]string, len(t.entries))
+	copy(result, t.entries)
+
+	return result
+}
+
+// RecordingExecutor records every request instead of running a command. The optional Handler
+// simulates the command, e.g. by writing to request.Stdout or returning an error.
+type RecordingExecutor struct {
+	Handler  func(ctx context.Context, request CommandRequest) error
+	requests []CommandRequest
+	lock     sync.Mutex
+}
+
+func (e *RecordingExecutor) Execute(ctx context.Context, request CommandRequest) error {
+	e.lock.Lock()
+	e.requests = append(e.requests, request)
+	e.lock.Unlock()
+
+	if e.Handler == nil {
+		return nil
+	}
+
+	return e.Handler(ctx, request)
+}
+
+// Requests returns the requests recorded so far, in the order they were executed.
+func (e *RecordingExecutor) Requests() []CommandRequest {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	result := make([]CommandRequest, len(e.requests))
+	copy(result, e.requests)
+
+	return result
+}
+
+// commandOutputFile returns a path in a new private temp directory for the output of a single
+// command so concurrent invocations never share a file and other users cannot read the output, which
+// can hold credentials. The returned function overwrites and removes the directory.
+func commandOutputFile(prefix string) (string, func(), error) {
+	dir, cleanup, err := privateTempDir(prefix)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return filepath.Join(dir, "output.json"), cleanup, nil
+}
+
+// gitCommitterEnv builds the environment that identifies the author of the commits made by the clis.
+func gitCommitterEnv() []string {
+	return []string{
+		"EMAIL=" + gitEmail,
+		"GIT_AUTHOR_EMAIL=" + gitEmail,
+		"GIT_AUTHOR_NAME=" + gitName,
+		"GIT_COMMITTER_EMAIL=" + gitEmail,
+		"GIT_COMMITTER_NAME=" + gitName,
+	}
+}
+
+// gitopsEnv builds the environment shared by the igc commands that update the gitops repo.
+func gitopsEnv(credentials string, config string) []string {
+	return append([]string{
+		"GIT_CREDENTIALS=" + credentials,
+		"GITOPS_CONFIG=" + config,
+	}, gitCommitterEnv()...)
+}
@@ -0,0 +1,104 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBinDir writes the script as the igc cli of a new bin dir
+func testBinDir(t *testing.T, script string) string {
+	t.Helper()
+
+	binDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(binDir, "igc"), []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return binDir
+}
+
+func TestBinDirExecutorDrainsStderrBeforeStdout(t *testing.T) {
+	// 256KiB of stderr, well over the pipe buffer, is written before anything reaches stdout. The
+	// command blocks forever unless stderr is read while waiting for stdout.
+	binDir := testBinDir(t, `i=0
+while [ $i -lt 4096 ]; do
+  echo "stderr line $i padded to sixty four bytes ......................" >&2
+  i=$((i+1))
+done
+echo "stdout done"
+`)
+
+	for _, captureStdout := range []bool{false, true} {
+		t.Run(fmt.Sprintf("capture stdout %t", captureStdout), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			request := CommandRequest{Name: "igc", Args: []string{"gitops-module"}}
+
+			var stdout bytes.Buffer
+			if captureStdout {
+				request.Stdout = &stdout
+			}
+
+			err := NewExecutor(binDir).Execute(ctx, request)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if captureStdout && stdout.String() != "stdout done\n" {
+				t.Errorf("unexpected stdout %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestBinDirExecutorKeepsStderrTail(t *testing.T) {
+	binDir := testBinDir(t, `i=1
+while [ $i -le 100 ]; do
+  echo "stderr line $i" >&2
+  i=$((i+1))
+done
+exit 3
+`)
+
+	err := NewExecutor(binDir).Execute(context.Background(), CommandRequest{Name: "igc", Args: []string{"gitops-module", "my-module"}})
+
+	var commandError *CommandError
+	if !errors.As(err, &commandError) {
+		t.Fatalf("expected a CommandError, got %v", err)
+	}
+
+	if commandError.Command != "igc gitops-module" {
+		t.Errorf("unexpected command %q", commandError.Command)
+	}
+	if len(commandError.Stderr) != stderrTailLines {
+		t.Fatalf("expected the last %d lines of stderr, got %d", stderrTailLines, len(commandError.Stderr))
+	}
+	if commandError.Stderr[0] != "stderr line 61" || commandError.Stderr[stderrTailLines-1] != "stderr line 100" {
+		t.Errorf("expected lines 61 to 100, got %q to %q", commandError.Stderr[0], commandError.Stderr[stderrTailLines-1])
+	}
+}
+
+func TestLineTailTruncatesLongLines(t *testing.T) {
+	tail := newLineTail(2)
+	tail.add("first")
+	tail.add(strings.Repeat("x", stderrTailLineWidth+10))
+	tail.add("last")
+
+	lines := tail.lines()
+	if len(lines) != 2 || lines[1] != "last" {
+		t.Fatalf("expected the last 2 lines, got %d lines", len(lines))
+	}
+	if lines[0] != strings.Repeat("x", stderrTailLineWidth)+"..." {
+		t.Errorf("expected the long line to be truncated to %d characters, got %d", stderrTailLineWidth, len(lines[0]))
+	}
+}
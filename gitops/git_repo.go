@@ -0,0 +1,258 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"os"
+	"strings"
+	"time"
+)
+
+const gitEmail = "cloudnativetoolkit@gmail.com"
+const gitName = "Cloud Native Toolkit"
+
+type GitRepoCheckout struct {
+	Url      string
+	Branch   string
+	Dir      string
+	repo     *git.Repository
+	auth     transport.AuthMethod
+	caBundle []byte
+}
+
+func parseGitCredentials(credentials string) ([]GitCredential, error) {
+	result := []GitCredential{}
+
+	if len(credentials) == 0 {
+		return result, nil
+	}
+
+	err := json.Unmarshal([]byte(credentials), &result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse git credentials: %w", err)
+	}
+
+	return result, nil
+}
+
+func normalizeGitUrl(url string) string {
+	result := strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+	result = strings.TrimPrefix(result, "https://")
+	result = strings.TrimPrefix(result, "http://")
+
+	return strings.ToLower(result)
+}
+
+func findGitCredential(credentials []GitCredential, url string) *GitCredential {
+	var wildcard *GitCredential
+
+	for i := range credentials {
+		credential := credentials[i]
+
+		if credential.Url == "*" || credential.Repo == "*" {
+			wildcard = &credentials[i]
+			continue
+		}
+
+		if normalizeGitUrl(credential.Url) == normalizeGitUrl(url) || normalizeGitUrl(credential.Repo) == normalizeGitUrl(url) {
+			return &credentials[i]
+		}
+	}
+
+	return wildcard
+}
+
+func readCaBundle(caCertFile string) ([]byte, error) {
+	if len(caCertFile) == 0 {
+		return nil, nil
+	}
+
+	caBundle, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca cert file %s: %w", caCertFile, err)
+	}
+
+	return caBundle, nil
+}
+
+func gitAuth(credential *GitCredential) transport.AuthMethod {
+	if credential == nil {
+		return nil
+	}
+
+	return &http.BasicAuth{
+		Username: credential.Username,
+		Password: credential.Token,
+	}
+}
+
+// gitRepoExists lists the references of the remote repo to check that it exists without cloning it.
+func gitRepoExists(ctx context.Context, url string, credential *GitCredential, caCertFile string) (bool, error) {
+	caBundle, err := readCaBundle(caCertFile)
+	if err != nil {
+		return false, err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	_, err = remote.ListContext(ctx, &git.ListOptions{
+		Auth:     gitAuth(credential),
+		CABundle: caBundle,
+	})
+	if errors.Is(err, transport.ErrRepositoryNotFound) {
+		return false, nil
+	} else if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("unable to list references of %s: %w", url, err)
+	}
+
+	return true, nil
+}
+
+// cloneGitRepo checks out the branch of the repo into a new temp dir. A depth of 1 fetches only the
+// latest commit, which is enough to read the repo and push a commit on top of it, while 0 fetches
+// the full history.
+func cloneGitRepo(ctx context.Context, url string, branch string, credential *GitCredential, caCertFile string, depth int) (*GitRepoCheckout, error) {
+	dir, err := os.MkdirTemp("", "gitops-repo-")
+	if err != nil {
+		return nil, err
+	}
+
+	caBundle, err := readCaBundle(caCertFile)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, err
+	}
+
+	auth := gitAuth(credential)
+
+	tflog.Debug(ctx, fmt.Sprintf("Cloning gitops repo: url=%s, branch=%s, dir=%s", url, branch, dir))
+
+	repo, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(branch),
+		SingleBranch:  true,
+		Depth:         depth,
+		CABundle:      caBundle,
+	})
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return nil, fmt.Errorf("unable to clone branch %s of %s: %w", branch, url, err)
+	}
+
+	return &GitRepoCheckout{
+		Url:      url,
+		Branch:   branch,
+		Dir:      dir,
+		repo:     repo,
+		auth:     auth,
+		caBundle: caBundle,
+	}, nil
+}
+
+// CommitAndPush stages every change in the checkout, commits it and pushes the branch. The returned
+// flag is false when there was nothing to commit.
+func (c *GitRepoCheckout) CommitAndPush(ctx context.Context, message string) (bool, error) {
+	committed, err := c.Commit(ctx, message)
+	if err != nil || !committed {
+		return committed, err
+	}
+
+	return true, c.Push(ctx)
+}
+
+// Commit stages every change in the checkout and commits it without pushing. The returned flag is
+// false when there was nothing to commit.
+func (c *GitRepoCheckout) Commit(ctx context.Context, message string) (bool, error) {
+	worktree, err := c.repo.Worktree()
+	if err != nil {
+		return false, err
+	}
+
+	err = worktree.AddWithOptions(&git.AddOptions{All: true})
+	if err != nil {
+		return false, fmt.Errorf("unable to stage changes in %s: %w", c.Url, err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return false, err
+	}
+
+	// adding all changes does not stage the files that were deleted, so they are removed one by one
+	removed := false
+	for path, fileStatus := range status {
+		if fileStatus.Worktree != git.Deleted {
+			continue
+		}
+
+		_, err = worktree.Remove(path)
+		if err != nil {
+			return false, fmt.Errorf("unable to stage removal of %s in %s: %w", path, c.Url, err)
+		}
+
+		removed = true
+	}
+
+	if removed {
+		status, err = worktree.Status()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if status.IsClean() {
+		tflog.Info(ctx, fmt.Sprintf("No changes to commit to %s", c.Url))
+		return false, nil
+	}
+
+	signature := &object.Signature{
+		Name:  gitName,
+		Email: gitEmail,
+		When:  time.Now(),
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:    signature,
+		Committer: signature,
+	})
+	if err != nil {
+		return false, fmt.Errorf("unable to commit changes to %s: %w", c.Url, err)
+	}
+
+	return true, nil
+}
+
+// Push pushes the commits of the checkout to the branch
+func (c *GitRepoCheckout) Push(ctx context.Context) error {
+	tflog.Debug(ctx, fmt.Sprintf("Pushing branch %s to %s", c.Branch, c.Url))
+
+	err := c.repo.PushContext(ctx, &git.PushOptions{
+		Auth:     c.auth,
+		CABundle: c.caBundle,
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("unable to push branch %s to %s: %w", c.Branch, c.Url, err)
+	}
+
+	return nil
+}
+
+func (c *GitRepoCheckout) Close() {
+	_ = os.RemoveAll(c.Dir)
+}
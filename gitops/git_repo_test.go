@@ -0,0 +1,190 @@
+package gitops
+
+import (
+	"context"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestFile writes the file under dir, creating the parent dirs
+func writeTestFile(t *testing.T, dir string, path string, contents string) {
+	t.Helper()
+
+	path = filepath.Join(dir, filepath.FromSlash(path))
+
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testBareRepo creates a local bare repo with an initial commit on the main branch and returns its
+// file:// url
+func testBareRepo(t *testing.T, name string) string {
+	t.Helper()
+
+	bareDir := filepath.Join(t.TempDir(), name+".git")
+
+	_, err := git.PlainInit(bareDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "file://" + filepath.ToSlash(bareDir)
+
+	workDir := t.TempDir()
+
+	repo, err := git.PlainInit(workDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, workDir, "README.md", "# "+name+"\n")
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = worktree.Add("README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signature := &object.Signature{Name: gitName, Email: gitEmail, When: time.Now()}
+	_, err = worktree.Commit("Initial commit", &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{"refs/heads/master:refs/heads/main"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return url
+}
+
+// testCheckout clones the main branch of the repo and removes the checkout at the end of the test
+func testCheckout(t *testing.T, url string) *GitRepoCheckout {
+	t.Helper()
+
+	checkout, err := cloneGitRepo(context.Background(), url, "main", nil, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(checkout.Close)
+
+	return checkout
+}
+
+// testCommitMessages returns the messages of the commits on the main branch of the repo, newest first
+func testCommitMessages(t *testing.T, url string) []string {
+	t.Helper()
+
+	checkout := testCheckout(t, url)
+
+	commits, err := checkout.repo.Log(&git.LogOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{}
+	err = commits.ForEach(func(commit *object.Commit) error {
+		messages = append(messages, strings.TrimSpace(commit.Message))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return messages
+}
+
+func TestGitRepoExists(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+
+	exists, err := gitRepoExists(context.Background(), url, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("expected the repo to exist")
+	}
+
+	exists, err = gitRepoExists(context.Background(), "file://"+filepath.ToSlash(filepath.Join(t.TempDir(), "missing.git")), nil, "")
+	if exists {
+		t.Errorf("expected the missing repo not to exist, got error %v", err)
+	}
+}
+
+func TestGitRepoCheckoutCommitAndPush(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+
+	checkout := testCheckout(t, url)
+	if checkout.Branch != "main" || !fileExists(filepath.Join(checkout.Dir, "README.md")) {
+		t.Fatalf("expected a checkout of the main branch in %s", checkout.Dir)
+	}
+
+	committed, err := checkout.CommitAndPush(context.Background(), "Nothing to commit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if committed {
+		t.Error("expected nothing to be committed for a clean checkout")
+	}
+
+	writeTestFile(t, checkout.Dir, "argocd/app.yaml", "kind: Application\n")
+	err = os.Remove(filepath.Join(checkout.Dir, "README.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	committed, err = checkout.CommitAndPush(context.Background(), "Adds app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !committed {
+		t.Fatal("expected the changes to be committed")
+	}
+
+	// a fresh clone sees the added and removed files
+	clone := testCheckout(t, url)
+	if !fileExists(filepath.Join(clone.Dir, "argocd", "app.yaml")) || fileExists(filepath.Join(clone.Dir, "README.md")) {
+		t.Error("expected the pushed changes in a new clone")
+	}
+
+	if messages := testCommitMessages(t, url); messages[0] != "Adds app" {
+		t.Errorf("expected the pushed commit, got %v", messages)
+	}
+
+	checkout.Close()
+	if fileExists(checkout.Dir) {
+		t.Error("expected the checkout to be removed")
+	}
+}
+
+func TestCloneGitRepoMissingBranch(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+
+	_, err := cloneGitRepo(context.Background(), url, "missing", nil, "", 1)
+	if err == nil || !strings.Contains(err.Error(), "unable to clone branch missing") {
+		t.Errorf("expected the clone to fail, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// gitopsConfigFile is the file at the root of the bootstrap repo that holds the gitops config
+const gitopsConfigFile = "config.yaml"
+
+// gitopsMetadataFile is the file, under the infrastructure payload path, that holds the metadata of
+// a cluster: payload/1-infrastructure/cluster/<server>/metadata.yaml
+const gitopsMetadataFile = "metadata.yaml"
+
+// lookupGitopRepoConfigNative makes a shallow checkout of the bootstrap repo and reads the gitops
+// config from its config.yaml, the same file igc gitops-config reads
+func lookupGitopRepoConfigNative(ctx context.Context, input *GitopsRepoReadConfig) (*GitopsConfigResult, error) {
+	if len(input.CaCert) > 0 && len(input.CaCertFile) == 0 {
+		return nil, errors.New("ca_cert is passed to the igc cli, set ca_cert_file with git_engine = \"native\"")
+	}
+
+	var credential *GitCredential
+	if len(input.Username) > 0 || len(input.Token) > 0 {
+		credential = &GitCredential{
+			Url:      input.BootstrapUrl,
+			Username: input.Username,
+			Token:    input.Token,
+		}
+	}
+
+	repo, err := cloneGitRepo(ctx, input.BootstrapUrl, input.Branch, credential, input.CaCertFile, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	dat, err := os.ReadFile(filepath.Join(repo.Dir, gitopsConfigFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("gitops config %s not found in %s", gitopsConfigFile, input.BootstrapUrl)
+	} else if err != nil {
+		return nil, err
+	}
+
+	tflog.Debug(ctx, fmt.Sprintf("Gitops config from %s: %s", input.BootstrapUrl, redact(string(dat))))
+
+	return parseGitopsConfig(string(dat))
+}
+
+// readGitopsMetadataNative makes a shallow checkout of the infrastructure payload repo and reads the
+// metadata of the cluster. Empty metadata is returned when the cluster has none.
+func readGitopsMetadataNative(ctx context.Context, gitopsConfig GitopsMetadataConfig) (*GitopsMetadata, error) {
+	config, err := parseGitopsConfig(gitopsConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := config.Infrastructure.Payload
+	if len(payload.Url) == 0 {
+		return nil, errors.New("gitops config does not define the repo url for the infrastructure layer")
+	}
+
+	credentials, err := parseGitCredentials(gitopsConfig.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := cloneGitRepo(ctx, payload.Url, gitopsConfig.Branch, findGitCredential(credentials, payload.Url), gitopsConfig.CaCert, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer repo.Close()
+
+	metadataFile := filepath.Join(payload.Path, "cluster", gitopsConfig.ServerName, gitopsMetadataFile)
+
+	dat, err := os.ReadFile(filepath.Join(repo.Dir, metadataFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		tflog.Info(ctx, fmt.Sprintf("Gitops metadata %s not found in %s", metadataFile, payload.Url))
+
+		return &GitopsMetadata{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	gitopsMetadata := GitopsMetadata{}
+	err = yaml.Unmarshal(dat, &gitopsMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse gitops metadata %s: %w", metadataFile, err)
+	}
+
+	return &gitopsMetadata, nil
+}
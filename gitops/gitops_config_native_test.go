@@ -0,0 +1,124 @@
+package gitops
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// testPushFiles writes the files to the main branch of the repo
+func testPushFiles(t *testing.T, url string, files map[string]string) {
+	t.Helper()
+
+	checkout := testCheckout(t, url)
+	for path, contents := range files {
+		writeTestFile(t, checkout.Dir, path, contents)
+	}
+
+	_, err := checkout.CommitAndPush(context.Background(), "Adds test files")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testNativeProviderConfig() *ProviderConfig {
+	providerConfig := testProviderConfig(nil)
+	providerConfig.GitConfig.CaCertFile = ""
+	providerConfig.Engine = "native"
+	providerConfig.Retry = RetryConfig{MaxAttempts: 1}
+
+	return providerConfig
+}
+
+func TestDataGitopsRepoConfigReadNative(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+	testPushFiles(t, url, map[string]string{"config.yaml": `bootstrap:
+  argocd-config:
+    project: 0-bootstrap
+    url: https://github.com/org/gitops
+    path: argocd/0-bootstrap/cluster/default
+infrastructure:
+  argocd-config:
+    project: 1-infrastructure
+    url: https://github.com/org/gitops
+    path: argocd/1-infrastructure
+  payload:
+    url: https://github.com/org/gitops
+    path: payload/1-infrastructure
+`})
+
+	d := dataGitopsRepoConfig().TestResourceData()
+	_ = d.Set("bootstrap_url", url)
+	_ = d.Set("branch", "main")
+	_ = d.Set("username", "admin")
+
+	diags := dataGitopsRepoConfigRead(context.Background(), d, testNativeProviderConfig())
+	assertNoErrors(t, diags)
+
+	result, err := parseGitopsConfig(d.Get("gitops_config").(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Infrastructure.Payload.Path != "payload/1-infrastructure" || result.Infrastructure.ArgocdConfig.Project != "1-infrastructure" {
+		t.Errorf("unexpected infrastructure layer: %+v", result.Infrastructure)
+	}
+	if result.Boostrap.ArgocdConfig.Path != "argocd/0-bootstrap/cluster/default" {
+		t.Errorf("expected the bootstrap config under both spellings, got %+v", result.Boostrap)
+	}
+}
+
+func TestDataGitopsRepoConfigReadNativeMissingConfig(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+
+	d := dataGitopsRepoConfig().TestResourceData()
+	_ = d.Set("bootstrap_url", url)
+	_ = d.Set("branch", "main")
+
+	diags := dataGitopsRepoConfigRead(context.Background(), d, testNativeProviderConfig())
+	if !diags.HasError() || !strings.Contains(diags[0].Summary, "config.yaml not found") {
+		t.Errorf("expected the missing config.yaml to be reported, got %v", diags)
+	}
+}
+
+func TestReadGitopsMetadataNative(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+	testPushFiles(t, url, map[string]string{"payload/1-infrastructure/cluster/cluster1/metadata.yaml": `cluster:
+  type: ocp4
+  kubeVersion: v1.25.4
+  defaultIngressSubdomain: apps.example.com
+packages:
+  - packageName: cert-manager
+    catalogSource: community-operators
+    defaultChannel: stable
+`})
+
+	metadataConfig := GitopsMetadataConfig{
+		Branch:     "main",
+		ServerName: "cluster1",
+		Config:     testLayerConfig(url, url),
+	}
+
+	metadata, err := readGitopsMetadata(context.Background(), testNativeProviderConfig(), metadataConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metadata.Cluster.Type != "ocp4" || metadata.Cluster.KubeVersion != "v1.25.4" || metadata.Cluster.DefaultIngressSubdomain != "apps.example.com" {
+		t.Errorf("unexpected cluster metadata: %+v", metadata.Cluster)
+	}
+	if len(metadata.Packages) != 1 || metadata.Packages[0].PackageName != "cert-manager" || metadata.Packages[0].DefaultChannel != "stable" {
+		t.Errorf("unexpected package metadata: %+v", metadata.Packages)
+	}
+
+	// a server without metadata reads as empty metadata
+	metadataConfig.ServerName = "cluster2"
+
+	metadata, err = readGitopsMetadata(context.Background(), testNativeProviderConfig(), metadataConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Cluster != (GitopsMetadataCluster{}) || len(metadata.Packages) != 0 {
+		t.Errorf("expected empty metadata for cluster2, got %+v", metadata)
+	}
+}
@@ -0,0 +1,604 @@
+package gitops
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type ArgocdApplication struct {
+	ApiVersion string                `yaml:"apiVersion"`
+	Kind       string                `yaml:"kind"`
+	Metadata   ArgocdMetadata        `yaml:"metadata"`
+	Spec       ArgocdApplicationSpec `yaml:"spec"`
+}
+
+type ArgocdMetadata struct {
+	Name   string            `yaml:"name"`
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+type ArgocdApplicationSpec struct {
+	Destination       ArgocdDestination        `yaml:"destination"`
+	Project           string                   `yaml:"project"`
+	Source            ArgocdSource             `yaml:"source"`
+	SyncPolicy        ArgocdSyncPolicy         `yaml:"syncPolicy"`
+	IgnoreDifferences []map[string]interface{} `yaml:"ignoreDifferences,omitempty"`
+}
+
+type ArgocdDestination struct {
+	Namespace string `yaml:"namespace"`
+	Server    string `yaml:"server,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+}
+
+type ArgocdSource struct {
+	RepoURL        string            `yaml:"repoURL"`
+	Path           string            `yaml:"path,omitempty"`
+	TargetRevision string            `yaml:"targetRevision"`
+	Helm           *ArgocdHelmSource `yaml:"helm,omitempty"`
+}
+
+type ArgocdHelmSource struct {
+	ReleaseName string   `yaml:"releaseName"`
+	ValueFiles  []string `yaml:"valueFiles,omitempty"`
+}
+
+type HelmChartDependency struct {
+	Name       string `yaml:"name"`
+	Version    string `yaml:"version"`
+	Repository string `yaml:"repository"`
+}
+
+type HelmChart struct {
+	ApiVersion   string                `yaml:"apiVersion"`
+	Name         string                `yaml:"name"`
+	Description  string                `yaml:"description"`
+	Type         string                `yaml:"type"`
+	Version      string                `yaml:"version"`
+	Dependencies []HelmChartDependency `yaml:"dependencies"`
+}
+
+type ArgocdSyncPolicy struct {
+	Automated ArgocdAutomatedSync `yaml:"automated"`
+}
+
+type ArgocdAutomatedSync struct {
+	Prune    bool `yaml:"prune"`
+	SelfHeal bool `yaml:"selfHeal"`
+}
+
+type GitopsModuleLayout struct {
+	ArgocdConfig    ArgocdConfig
+	PayloadConfig   PayloadConfig
+	ApplicationName string
+	ApplicationDir  string
+	ApplicationFile string
+	PayloadDir      string
+}
+
+func (c GitopsConfigResult) layerConfig(layer string) (*LayerConfig, error) {
+	switch layer {
+	case "infrastructure":
+		return &c.Infrastructure, nil
+	case "services":
+		return &c.Services, nil
+	case "applications":
+		return &c.Applications, nil
+	}
+
+	return nil, fmt.Errorf("unknown gitops layer: %s", layer)
+}
+
+// parseGitopsConfig parses the gitops config of the resources, the lock keys and the config.yaml of
+// the bootstrap repo. yaml is a superset of json so this handles both the jsonencode and yamlencode
+// forms.
+func parseGitopsConfig(config string) (*GitopsConfigResult, error) {
+	result := GitopsConfigResult{}
+
+	err := yaml.Unmarshal([]byte(config), &result)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse gitops config: %w", err)
+	}
+
+	// igc writes the bootstrap config under both spellings, so either one is accepted
+	if result.Bootstrap == (BootstrapConfig{}) {
+		result.Bootstrap = result.Boostrap
+	} else if result.Boostrap == (BootstrapConfig{}) {
+		result.Boostrap = result.Bootstrap
+	}
+
+	return &result, nil
+}
+
+func applicationName(gitopsConfig GitopsModuleConfig) string {
+	// the namespace module is stored as namespace-<name>, the same as gitops-namespace
+	if gitopsConfig.Name == "namespace" {
+		return fmt.Sprintf("namespace-%s", gitopsConfig.Namespace)
+	}
+
+	return fmt.Sprintf("%s-%s", gitopsConfig.Namespace, gitopsConfig.Name)
+}
+
+// gitopsModuleLayout resolves where the ArgoCD application and the payload of a module live in the
+// gitops repo: argocd/<layer>/cluster/<server>/<type>/<namespace>-<name>.yaml and
+// payload/<layer>/namespace/<namespace>/<name>.
+func gitopsModuleLayout(gitopsConfig GitopsModuleConfig) (*GitopsModuleLayout, error) {
+	config, err := parseGitopsConfig(gitopsConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	layerConfig, err := config.layerConfig(gitopsConfig.Layer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(layerConfig.ArgocdConfig.Url) == 0 || len(layerConfig.Payload.Url) == 0 {
+		return nil, fmt.Errorf("gitops config does not define the repo url for the %s layer", gitopsConfig.Layer)
+	}
+
+	applicationDir := filepath.Join(layerConfig.ArgocdConfig.Path, "cluster", gitopsConfig.ServerName, gitopsConfig.Type)
+
+	return &GitopsModuleLayout{
+		ArgocdConfig:    layerConfig.ArgocdConfig,
+		PayloadConfig:   layerConfig.Payload,
+		ApplicationName: applicationName(gitopsConfig),
+		ApplicationDir:  applicationDir,
+		ApplicationFile: filepath.Join(applicationDir, applicationName(gitopsConfig)+".yaml"),
+		PayloadDir:      filepath.Join(layerConfig.Payload.Path, "namespace", gitopsConfig.Namespace, gitopsConfig.Name),
+	}, nil
+}
+
+// populateGitopsModuleNative writes the module to the gitops repo and returns the digest of the
+// ArgoCD application and payload that were pushed, so they do not need to be read back
+func populateGitopsModuleNative(ctx context.Context, gitopsConfig GitopsModuleConfig, delete bool) (string, error) {
+	layout, err := gitopsModuleLayout(gitopsConfig)
+	if err != nil {
+		return "", err
+	}
+
+	credentials, err := parseGitCredentials(gitopsConfig.Credentials)
+	if err != nil {
+		return "", err
+	}
+
+	argocdRepo, err := cloneGitRepo(ctx, layout.ArgocdConfig.Url, gitopsConfig.Branch, findGitCredential(credentials, layout.ArgocdConfig.Url), gitopsConfig.CaCert, 1)
+	if err != nil {
+		return "", err
+	}
+	defer argocdRepo.Close()
+
+	payloadRepo := argocdRepo
+	if normalizeGitUrl(layout.PayloadConfig.Url) != normalizeGitUrl(layout.ArgocdConfig.Url) {
+		payloadRepo, err = cloneGitRepo(ctx, layout.PayloadConfig.Url, gitopsConfig.Branch, findGitCredential(credentials, layout.PayloadConfig.Url), gitopsConfig.CaCert, 1)
+		if err != nil {
+			return "", err
+		}
+		defer payloadRepo.Close()
+	}
+
+	var message string
+	if delete {
+		message = fmt.Sprintf("Removes %s module from %s/%s in %s layer", gitopsConfig.Name, gitopsConfig.Namespace, gitopsConfig.ServerName, gitopsConfig.Layer)
+	} else {
+		message = fmt.Sprintf("Adds %s module to %s/%s in %s layer", gitopsConfig.Name, gitopsConfig.Namespace, gitopsConfig.ServerName, gitopsConfig.Layer)
+	}
+
+	// both repos are written and committed before anything is pushed, so an invalid payload or
+	// application fails the operation without changing the gitops repo
+	err = writeModulePayload(ctx, payloadRepo.Dir, layout, gitopsConfig, delete)
+	if err != nil {
+		return "", err
+	}
+
+	err = writeModuleApplication(ctx, argocdRepo.Dir, layout, gitopsConfig, delete)
+	if err != nil {
+		return "", err
+	}
+
+	err = pushModuleRepos(ctx, argocdRepo, payloadRepo, message, delete)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := gitopsModuleStateFromCheckout(layout, argocdRepo.Dir, payloadRepo.Dir)
+	if err != nil {
+		return "", err
+	}
+
+	return state.Digest, nil
+}
+
+// pushModuleRepos commits the changes to the argocd and payload repos and pushes them. The
+// application is pushed last when it is added, so ArgoCD never sees an application without
+// content, and first when it is removed, so ArgoCD never sees an application whose content is gone.
+func pushModuleRepos(ctx context.Context, argocdRepo *GitRepoCheckout, payloadRepo *GitRepoCheckout, message string, delete bool) error {
+	if payloadRepo == argocdRepo {
+		_, err := argocdRepo.CommitAndPush(ctx, message)
+
+		return err
+	}
+
+	payloadCommitted, err := payloadRepo.Commit(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	argocdCommitted, err := argocdRepo.Commit(ctx, message)
+	if err != nil {
+		return err
+	}
+
+	first, second := payloadRepo, argocdRepo
+	firstCommitted, secondCommitted := payloadCommitted, argocdCommitted
+	if delete {
+		first, second = argocdRepo, payloadRepo
+		firstCommitted, secondCommitted = argocdCommitted, payloadCommitted
+	}
+
+	if firstCommitted {
+		err = first.Push(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !secondCommitted {
+		return nil
+	}
+
+	err = second.Push(ctx)
+	if err != nil && firstCommitted {
+		return fmt.Errorf("the change to %s was pushed but the change to %s was not, re-run the apply to complete the change: %w", first.Url, second.Url, err)
+	}
+
+	return err
+}
+
+func writeModulePayload(ctx context.Context, repoDir string, layout *GitopsModuleLayout, gitopsConfig GitopsModuleConfig, delete bool) error {
+	payloadDir := filepath.Join(repoDir, layout.PayloadDir)
+
+	err := os.RemoveAll(payloadDir)
+	if err != nil {
+		return err
+	}
+
+	if delete {
+		return nil
+	}
+
+	if len(gitopsConfig.ContentDir) > 0 {
+		tflog.Debug(ctx, fmt.Sprintf("Copying module content from %s to %s", gitopsConfig.ContentDir, layout.PayloadDir))
+
+		return copyDir(gitopsConfig.ContentDir, payloadDir)
+	}
+
+	if gitopsConfig.HelmConfig == nil {
+		return errors.New("contentDir or helmRepoUrl, helmChart, and helmChartVersion are required")
+	}
+
+	tflog.Debug(ctx, fmt.Sprintf("Writing helm chart %s to %s", gitopsConfig.HelmConfig.Chart, layout.PayloadDir))
+
+	return writeHelmPayload(payloadDir, gitopsConfig)
+}
+
+// helmConfigFromChart recovers the helm config from a payload Chart.yaml written for a helm module.
+// Nil is returned when the payload came from a content_dir.
+func helmConfigFromChart(chart *HelmChart, name string) *HelmConfig {
+	if chart == nil || chart.Name != name || len(chart.Dependencies) != 1 {
+		return nil
+	}
+
+	dependency := chart.Dependencies[0]
+	if chart.Description != fmt.Sprintf("Chart to deploy %s", dependency.Name) {
+		return nil
+	}
+
+	return &HelmConfig{
+		RepoUrl:      dependency.Repository,
+		Chart:        dependency.Name,
+		ChartVersion: dependency.Version,
+	}
+}
+
+// writeHelmPayload writes a chart that depends on the configured helm chart, with the value files
+// merged into values.yaml under the name of the dependency
+func writeHelmPayload(payloadDir string, gitopsConfig GitopsModuleConfig) error {
+	helmConfig := gitopsConfig.HelmConfig
+
+	chart := HelmChart{
+		ApiVersion:  "v2",
+		Name:        gitopsConfig.Name,
+		Description: fmt.Sprintf("Chart to deploy %s", helmConfig.Chart),
+		Type:        "application",
+		Version:     "0.1.0",
+		Dependencies: []HelmChartDependency{{
+			Name:       helmConfig.Chart,
+			Version:    helmConfig.ChartVersion,
+			Repository: helmConfig.RepoUrl,
+		}},
+	}
+
+	chartData, err := yaml.Marshal(chart)
+	if err != nil {
+		return err
+	}
+
+	var valueFiles []string
+	if len(gitopsConfig.ValueFiles) > 0 {
+		valueFiles = strings.Split(gitopsConfig.ValueFiles, ",")
+	}
+
+	values, err := readValueFiles(valueFiles)
+	if err != nil {
+		return err
+	}
+
+	valuesData, err := yaml.Marshal(map[string]interface{}{helmConfig.Chart: values})
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(payloadDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(filepath.Join(payloadDir, "Chart.yaml"), chartData, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(payloadDir, "values.yaml"), valuesData, 0644)
+}
+
+func writeModuleApplication(ctx context.Context, repoDir string, layout *GitopsModuleLayout, gitopsConfig GitopsModuleConfig, delete bool) error {
+	applicationFile := filepath.Join(repoDir, layout.ApplicationFile)
+	fileName := filepath.Base(applicationFile)
+
+	if delete {
+		err := os.Remove(applicationFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	} else {
+		application, err := buildArgocdApplication(layout, gitopsConfig)
+		if err != nil {
+			return err
+		}
+
+		data, err := yaml.Marshal(application)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(applicationFile), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		tflog.Debug(ctx, fmt.Sprintf("Writing ArgoCD application: %s", layout.ApplicationFile))
+
+		err = os.WriteFile(applicationFile, data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return updateKustomization(filepath.Join(repoDir, layout.ApplicationDir), fileName, delete)
+}
+
+func buildArgocdApplication(layout *GitopsModuleLayout, gitopsConfig GitopsModuleConfig) (*ArgocdApplication, error) {
+	destination := ArgocdDestination{
+		Namespace: gitopsConfig.Namespace,
+	}
+	if gitopsConfig.ServerName == "default" {
+		destination.Server = "https://kubernetes.default.svc"
+	} else {
+		destination.Name = gitopsConfig.ServerName
+	}
+
+	source := ArgocdSource{
+		RepoURL:        layout.PayloadConfig.Url,
+		Path:           layout.PayloadDir,
+		TargetRevision: gitopsConfig.Branch,
+	}
+
+	if len(gitopsConfig.ContentDir) > 0 {
+		var valueFiles []string
+		if len(gitopsConfig.ValueFiles) > 0 {
+			valueFiles = strings.Split(gitopsConfig.ValueFiles, ",")
+		}
+
+		if len(valueFiles) > 0 || fileExists(filepath.Join(gitopsConfig.ContentDir, "Chart.yaml")) {
+			source.Helm = &ArgocdHelmSource{
+				ReleaseName: gitopsConfig.Name,
+				ValueFiles:  valueFiles,
+			}
+		}
+	} else if gitopsConfig.HelmConfig != nil {
+		source.Helm = &ArgocdHelmSource{
+			ReleaseName: gitopsConfig.Name,
+		}
+	} else {
+		return nil, errors.New("contentDir or helmRepoUrl, helmChart, and helmChartVersion are required")
+	}
+
+	var ignoreDifferences []map[string]interface{}
+	if len(gitopsConfig.IgnoreDiff) > 0 {
+		err := json.Unmarshal([]byte(gitopsConfig.IgnoreDiff), &ignoreDifferences)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse ignore_diff: %w", err)
+		}
+	}
+
+	return &ArgocdApplication{
+		ApiVersion: "argoproj.io/v1alpha1",
+		Kind:       "Application",
+		Metadata: ArgocdMetadata{
+			Name: applicationName(gitopsConfig),
+			Labels: map[string]string{
+				"gitops.cloudnativetoolkit.dev/layer": gitopsConfig.Layer,
+			},
+		},
+		Spec: ArgocdApplicationSpec{
+			Destination: destination,
+			Project:     layout.ArgocdConfig.Project,
+			Source:      source,
+			SyncPolicy: ArgocdSyncPolicy{
+				Automated: ArgocdAutomatedSync{
+					Prune:    true,
+					SelfHeal: true,
+				},
+			},
+			IgnoreDifferences: ignoreDifferences,
+		},
+	}, nil
+}
+
+// readValueFiles merges the value files, in order, into a single set of values
+func readValueFiles(valueFiles []string) (map[string]interface{}, error) {
+	merged := map[string]interface{}{}
+
+	for _, valueFile := range valueFiles {
+		data, err := os.ReadFile(strings.TrimSpace(valueFile))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read value file %s: %w", valueFile, err)
+		}
+
+		current := map[string]interface{}{}
+
+		err = yaml.Unmarshal(data, &current)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse value file %s: %w", valueFile, err)
+		}
+
+		mergeValues(merged, current)
+	}
+
+	return merged, nil
+}
+
+func mergeValues(target map[string]interface{}, source map[string]interface{}) {
+	for key, value := range source {
+		sourceMap, sourceIsMap := value.(map[string]interface{})
+		targetMap, targetIsMap := target[key].(map[string]interface{})
+
+		if sourceIsMap && targetIsMap {
+			mergeValues(targetMap, sourceMap)
+		} else {
+			target[key] = value
+		}
+	}
+}
+
+// updateKustomization adds or removes the application file from the resources listed in the
+// kustomization.yaml of the application directory
+func updateKustomization(dir string, fileName string, delete bool) error {
+	kustomizationFile := filepath.Join(dir, "kustomization.yaml")
+
+	kustomization := map[string]interface{}{}
+
+	data, err := os.ReadFile(kustomizationFile)
+	if err == nil {
+		err = yaml.Unmarshal(data, &kustomization)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %w", kustomizationFile, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	} else if delete {
+		return nil
+	} else {
+		kustomization["apiVersion"] = "kustomize.config.k8s.io/v1beta1"
+		kustomization["kind"] = "Kustomization"
+	}
+
+	resources := []string{}
+	if rawResources, ok := kustomization["resources"].([]interface{}); ok {
+		resources = interfacesToStrings(rawResources)
+	}
+
+	updated := []string{}
+	for _, resource := range resources {
+		if resource != fileName {
+			updated = append(updated, resource)
+		}
+	}
+	if !delete {
+		updated = append(updated, fileName)
+	}
+	sort.Strings(updated)
+
+	kustomization["resources"] = updated
+
+	data, err = yaml.Marshal(kustomization)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(kustomizationFile, data, 0644)
+}
+
+// readYamlFile parses the yaml file into the given type. Nil is returned if the file is missing or
+// cannot be parsed.
+func readYamlFile[T any](path string) *T {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+
+	var result T
+	err = yaml.Unmarshal(data, &result)
+	if err != nil {
+		return nil
+	}
+
+	return &result
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
+
+func copyDir(sourceDir string, destDir string) error {
+	return filepath.WalkDir(sourceDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(destDir, relPath)
+
+		if entry.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(target, data, 0644)
+	})
+}
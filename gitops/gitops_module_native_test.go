@@ -0,0 +1,237 @@
+package gitops
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLayerConfig returns a gitops config with the infrastructure layer in the given argocd and
+// payload repos
+func testLayerConfig(argocdUrl string, payloadUrl string) string {
+	return `{
+  "infrastructure": {
+    "argocd-config": {"url": "` + argocdUrl + `", "path": "argocd/1-infrastructure"},
+    "payload": {"url": "` + payloadUrl + `", "path": "payload/1-infrastructure"}
+  }
+}`
+}
+
+// testNativeModuleConfig returns the config of a content_dir module in the given repos
+func testNativeModuleConfig(t *testing.T, argocdUrl string, payloadUrl string) GitopsModuleConfig {
+	contentDir := t.TempDir()
+	writeTestFile(t, contentDir, "deployment.yaml", "kind: Deployment\n")
+	writeTestFile(t, contentDir, "config/values.yaml", "replicas: 1\n")
+
+	return GitopsModuleConfig{
+		Name:       "my-module",
+		Namespace:  "my-namespace",
+		Branch:     "main",
+		ServerName: "default",
+		Layer:      "infrastructure",
+		Type:       "base",
+		ContentDir: contentDir,
+		Config:     testLayerConfig(argocdUrl, payloadUrl),
+	}
+}
+
+// testKustomizationResources returns the resources listed in the kustomization.yaml of the dir
+func testKustomizationResources(t *testing.T, dir string) []string {
+	t.Helper()
+
+	kustomization := readYamlFile[struct {
+		Resources []string `yaml:"resources"`
+	}](filepath.Join(dir, "kustomization.yaml"))
+	if kustomization == nil {
+		return nil
+	}
+
+	return kustomization.Resources
+}
+
+func TestGitopsModuleLayout(t *testing.T) {
+	config := testLayerConfig("https://github.com/org/gitops", "https://github.com/org/gitops")
+
+	layout, err := gitopsModuleLayout(GitopsModuleConfig{Name: "my-module", Namespace: "my-namespace", ServerName: "cluster1", Layer: "infrastructure", Type: "operators", Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := GitopsModuleLayout{
+		ArgocdConfig:    ArgocdConfig{Url: "https://github.com/org/gitops", Path: "argocd/1-infrastructure"},
+		PayloadConfig:   PayloadConfig{Url: "https://github.com/org/gitops", Path: "payload/1-infrastructure"},
+		ApplicationName: "my-namespace-my-module",
+		ApplicationDir:  filepath.FromSlash("argocd/1-infrastructure/cluster/cluster1/operators"),
+		ApplicationFile: filepath.FromSlash("argocd/1-infrastructure/cluster/cluster1/operators/my-namespace-my-module.yaml"),
+		PayloadDir:      filepath.FromSlash("payload/1-infrastructure/namespace/my-namespace/my-module"),
+	}
+	if !reflect.DeepEqual(*layout, expected) {
+		t.Errorf("unexpected layout\nexpected: %+v\nactual:   %+v", expected, *layout)
+	}
+
+	// the namespace module is stored under the name of the namespace
+	layout, err = gitopsModuleLayout(GitopsModuleConfig{Name: "namespace", Namespace: "my-namespace", ServerName: "default", Layer: "infrastructure", Type: "base", Config: config})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layout.ApplicationFile != filepath.FromSlash("argocd/1-infrastructure/cluster/default/base/namespace-my-namespace.yaml") {
+		t.Errorf("unexpected application file %s", layout.ApplicationFile)
+	}
+
+	_, err = gitopsModuleLayout(GitopsModuleConfig{Name: "my-module", Namespace: "my-namespace", Layer: "services", Config: config})
+	if err == nil || !strings.Contains(err.Error(), "services layer") {
+		t.Errorf("expected an error for a layer without repo urls, got %v", err)
+	}
+
+	_, err = gitopsModuleLayout(GitopsModuleConfig{Name: "my-module", Namespace: "my-namespace", Layer: "platform", Config: config})
+	if err == nil || !strings.Contains(err.Error(), "unknown gitops layer") {
+		t.Errorf("expected an error for an unknown layer, got %v", err)
+	}
+}
+
+func TestPopulateGitopsModuleNativeCreateAndDelete(t *testing.T) {
+	url := testBareRepo(t, "gitops")
+	moduleConfig := testNativeModuleConfig(t, url, url)
+
+	layout, err := gitopsModuleLayout(moduleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest, err := populateGitopsModuleNative(context.Background(), moduleConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkout := testCheckout(t, url)
+
+	application := readYamlFile[ArgocdApplication](filepath.Join(checkout.Dir, layout.ApplicationFile))
+	if application == nil {
+		t.Fatalf("expected the application %s to be pushed", layout.ApplicationFile)
+	}
+	if application.Metadata.Name != "my-namespace-my-module" || application.Spec.Source.Path != filepath.ToSlash(layout.PayloadDir) || application.Spec.Source.RepoURL != url {
+		t.Errorf("unexpected application %+v", application)
+	}
+
+	for _, file := range []string{"deployment.yaml", "config/values.yaml"} {
+		if !fileExists(filepath.Join(checkout.Dir, layout.PayloadDir, filepath.FromSlash(file))) {
+			t.Errorf("expected %s in the payload", file)
+		}
+	}
+
+	if resources := testKustomizationResources(t, filepath.Join(checkout.Dir, layout.ApplicationDir)); !reflect.DeepEqual(resources, []string{"my-namespace-my-module.yaml"}) {
+		t.Errorf("expected the application in the kustomization, got %v", resources)
+	}
+
+	// the digest returned after the push matches the one read back from the repo
+	state, err := readGitopsModuleState(context.Background(), moduleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.Exists || state.Digest != digest {
+		t.Errorf("expected the module to be read back with digest %s, got %+v", digest, state)
+	}
+
+	_, err = populateGitopsModuleNative(context.Background(), moduleConfig, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkout = testCheckout(t, url)
+
+	if fileExists(filepath.Join(checkout.Dir, layout.ApplicationFile)) || fileExists(filepath.Join(checkout.Dir, layout.PayloadDir)) {
+		t.Error("expected the application and payload to be removed from the repo")
+	}
+	if resources := testKustomizationResources(t, filepath.Join(checkout.Dir, layout.ApplicationDir)); len(resources) > 0 {
+		t.Errorf("expected the application to be removed from the kustomization, got %v", resources)
+	}
+
+	state, err = readGitopsModuleState(context.Background(), moduleConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Exists {
+		t.Error("expected the module to be missing after the delete")
+	}
+
+	expected := []string{
+		"Removes my-module module from my-namespace/default in infrastructure layer",
+		"Adds my-module module to my-namespace/default in infrastructure layer",
+		"Initial commit",
+	}
+	if messages := testCommitMessages(t, url); !reflect.DeepEqual(messages, expected) {
+		t.Errorf("unexpected commits\nexpected: %v\nactual:   %v", expected, messages)
+	}
+}
+
+func TestPushModuleReposOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		delete      bool
+		pushedRepo  string
+		missingRepo string
+	}{
+		// the payload is pushed before the application that points to it
+		{name: "create", delete: false, pushedRepo: "payload", missingRepo: "argocd"},
+		// the application is removed before the payload it points to
+		{name: "delete", delete: true, pushedRepo: "argocd", missingRepo: "payload"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			urls := map[string]string{
+				"argocd":  testBareRepo(t, "argocd"),
+				"payload": testBareRepo(t, "payload"),
+			}
+
+			argocdRepo := testCheckout(t, urls["argocd"])
+			payloadRepo := testCheckout(t, urls["payload"])
+
+			writeTestFile(t, argocdRepo.Dir, "argocd/app.yaml", "kind: Application\n")
+			writeTestFile(t, payloadRepo.Dir, "payload/deployment.yaml", "kind: Deployment\n")
+
+			// the second push fails because its remote is gone, which shows which repo is pushed first
+			err := os.RemoveAll(strings.TrimPrefix(urls[test.missingRepo], "file://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = pushModuleRepos(context.Background(), argocdRepo, payloadRepo, "Updates module", test.delete)
+			if err == nil || !strings.Contains(err.Error(), "was pushed but the change to "+urls[test.missingRepo]+" was not") {
+				t.Fatalf("expected the push of the %s repo to fail after the %s repo was pushed, got %v", test.missingRepo, test.pushedRepo, err)
+			}
+
+			if messages := testCommitMessages(t, urls[test.pushedRepo]); messages[0] != "Updates module" {
+				t.Errorf("expected the %s repo to be pushed first, got %v", test.pushedRepo, messages)
+			}
+		})
+	}
+}
+
+func TestPushModuleReposSkipsUnchangedRepo(t *testing.T) {
+	argocdUrl := testBareRepo(t, "argocd")
+	payloadUrl := testBareRepo(t, "payload")
+
+	argocdRepo := testCheckout(t, argocdUrl)
+	payloadRepo := testCheckout(t, payloadUrl)
+
+	writeTestFile(t, argocdRepo.Dir, "argocd/app.yaml", "kind: Application\n")
+
+	// nothing changed in the payload repo, so its missing remote is never contacted
+	err := os.RemoveAll(strings.TrimPrefix(payloadUrl, "file://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = pushModuleRepos(context.Background(), argocdRepo, payloadRepo, "Updates module", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := testCommitMessages(t, argocdUrl); messages[0] != "Updates module" {
+		t.Errorf("expected the argocd repo to be pushed, got %v", messages)
+	}
+}
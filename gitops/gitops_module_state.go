@@ -0,0 +1,281 @@
+package gitops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"hash"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type GitopsModuleState struct {
+	Exists          bool
+	ApplicationFile string
+	PayloadDir      string
+	Digest          string
+	Application     *ArgocdApplication
+	HelmChart       *HelmChart
+}
+
+// readGitopsModuleState makes a shallow checkout of the gitops repo and looks up the ArgoCD application and payload
+// of the module. The digest covers the contents of both so changes made outside of terraform can be
+// detected.
+func readGitopsModuleState(ctx context.Context, gitopsConfig GitopsModuleConfig) (*GitopsModuleState, error) {
+	layout, err := gitopsModuleLayout(gitopsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := parseGitCredentials(gitopsConfig.Credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	argocdRepo, err := cloneGitRepo(ctx, layout.ArgocdConfig.Url, gitopsConfig.Branch, findGitCredential(credentials, layout.ArgocdConfig.Url), gitopsConfig.CaCert, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer argocdRepo.Close()
+
+	payloadRepo := argocdRepo
+	if normalizeGitUrl(layout.PayloadConfig.Url) != normalizeGitUrl(layout.ArgocdConfig.Url) {
+		payloadRepo, err = cloneGitRepo(ctx, layout.PayloadConfig.Url, gitopsConfig.Branch, findGitCredential(credentials, layout.PayloadConfig.Url), gitopsConfig.CaCert, 1)
+		if err != nil {
+			return nil, err
+		}
+		defer payloadRepo.Close()
+	}
+
+	return gitopsModuleStateFromCheckout(layout, argocdRepo.Dir, payloadRepo.Dir)
+}
+
+// gitopsModuleStateFromCheckout looks up the ArgoCD application and payload of the module in the
+// checkouts of the argocd and payload repos. The layout is the one the native engine writes; igc may
+// put the application elsewhere under the argocd path, so the module is only reported missing when
+// neither the application nor the payload can be found.
+func gitopsModuleStateFromCheckout(layout *GitopsModuleLayout, argocdDir string, payloadDir string) (*GitopsModuleState, error) {
+	state := &GitopsModuleState{
+		Exists:          fileExists(filepath.Join(argocdDir, layout.ApplicationFile)),
+		ApplicationFile: layout.ApplicationFile,
+		PayloadDir:      layout.PayloadDir,
+	}
+
+	if !state.Exists {
+		applicationFile, err := findArgocdApplication(argocdDir, layout)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(applicationFile) > 0 {
+			state.ApplicationFile = applicationFile
+		}
+
+		state.Exists = len(applicationFile) > 0 || fileExists(filepath.Join(payloadDir, layout.PayloadDir))
+	}
+
+	if !state.Exists {
+		return state, nil
+	}
+
+	state.Application = readYamlFile[ArgocdApplication](filepath.Join(argocdDir, layout.ApplicationFile))
+	state.HelmChart = readYamlFile[HelmChart](filepath.Join(payloadDir, layout.PayloadDir, "Chart.yaml"))
+
+	digest := sha256.New()
+
+	err := hashRepoPath(digest, argocdDir, layout.ApplicationFile)
+	if err != nil {
+		return nil, err
+	}
+
+	err = hashRepoPath(digest, payloadDir, layout.PayloadDir)
+	if err != nil {
+		return nil, err
+	}
+
+	state.Digest = hex.EncodeToString(digest.Sum(nil))
+
+	return state, nil
+}
+
+// errApplicationFound stops the search of findArgocdApplication once the application is found
+var errApplicationFound = errors.New("application found")
+
+// findArgocdApplication searches the yaml files under the argocd path of the layer for the ArgoCD
+// application of the module, matched by name or by the payload path it points to. The path of the
+// file relative to the checkout is returned, or an empty string if there is none.
+func findArgocdApplication(argocdDir string, layout *GitopsModuleLayout) (string, error) {
+	applicationFile := ""
+
+	err := filepath.WalkDir(filepath.Join(argocdDir, layout.ArgocdConfig.Path), func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if extension := filepath.Ext(path); extension != ".yaml" && extension != ".yml" {
+			return nil
+		}
+
+		application := readYamlFile[ArgocdApplication](path)
+		if application == nil || application.Kind != "Application" {
+			return nil
+		}
+
+		if application.Metadata.Name != layout.ApplicationName && filepath.Clean(application.Spec.Source.Path) != filepath.Clean(layout.PayloadDir) {
+			return nil
+		}
+
+		applicationFile, err = filepath.Rel(argocdDir, path)
+		if err != nil {
+			return err
+		}
+
+		return errApplicationFound
+	})
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, errApplicationFound) {
+		return applicationFile, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
+
+// hashRepoPath adds the relative path and contents of every file under repoPath to the digest in
+// a stable order. Missing paths are skipped.
+func hashRepoPath(digest hash.Hash, repoDir string, repoPath string) error {
+	root := filepath.Join(repoDir, repoPath)
+
+	files := []string{}
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		relPath, err := filepath.Rel(repoDir, file)
+		if err != nil {
+			return err
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(digest, "%s\x00%d\x00", filepath.ToSlash(relPath), len(data))
+		_, _ = digest.Write(data)
+	}
+
+	return nil
+}
+
+// gitopsModuleContentDigest computes the digest of the files under contentDir and of the value
+// files, and of the content trigger when it is set. The flag is false when the content does not
+// exist yet, e.g. because it is generated during the apply.
+func gitopsModuleContentDigest(contentDir string, valueFiles string, contentTrigger string) (string, bool, error) {
+	digest := sha256.New()
+
+	if len(contentDir) > 0 {
+		if !fileExists(contentDir) {
+			return "", false, nil
+		}
+
+		err := hashRepoPath(digest, contentDir, ".")
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	if len(valueFiles) > 0 {
+		for _, valueFile := range strings.Split(valueFiles, ",") {
+			valueFile = strings.TrimSpace(valueFile)
+
+			// value files of a content_dir module are relative to the content_dir and already hashed
+			if len(contentDir) > 0 && fileExists(filepath.Join(contentDir, valueFile)) {
+				continue
+			}
+
+			if !fileExists(valueFile) {
+				return "", false, nil
+			}
+
+			err := hashRepoPath(digest, filepath.Dir(valueFile), filepath.Base(valueFile))
+			if err != nil {
+				return "", false, err
+			}
+		}
+	}
+
+	// only mixed in when set so the digest of a module without a trigger does not change
+	if len(contentTrigger) > 0 {
+		digest.Write([]byte("content_trigger\x00" + contentTrigger))
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), true, nil
+}
+
+// readGitopsModuleExists removes the resource from the state when the ArgoCD application of the
+// module is no longer in the gitops repo
+func readGitopsModuleExists(ctx context.Context, d *schema.ResourceData, gitopsConfig GitopsModuleConfig) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	state, err := readGitopsModuleState(ctx, gitopsConfig)
+	if err != nil {
+		return gitopsModuleReadWarning(err)
+	}
+
+	if !state.Exists {
+		tflog.Warn(ctx, fmt.Sprintf("ArgoCD application %s not found in gitops repo. Removing resource from state", state.ApplicationFile))
+
+		d.SetId("")
+	}
+
+	return diags
+}
+
+// gitopsModuleReadWarning reports a failure to read the module from the gitops repo as a warning, so
+// an unreachable repo or a config the provider cannot parse does not break the refresh
+func gitopsModuleReadWarning(err error) diag.Diagnostics {
+	return gitopsReadWarning("Unable to read the module from the gitops repo", err)
+}
+
+// gitopsReadWarning reports a failed read of the gitops repo as a warning. The resource is kept in
+// the state as is, since it cannot be told whether it still exists.
+func gitopsReadWarning(summary string, err error) diag.Diagnostics {
+	return diag.Diagnostics{{
+		Severity: diag.Warning,
+		Summary:  summary,
+		Detail:   err.Error(),
+	}}
+}
@@ -0,0 +1,94 @@
+package gitops
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func testModuleLayout(t *testing.T) *GitopsModuleLayout {
+	layout, err := gitopsModuleLayout(GitopsModuleConfig{
+		Name:       "my-module",
+		Namespace:  "my-namespace",
+		ServerName: "default",
+		Layer:      "infrastructure",
+		Type:       "base",
+		Config:     testGitopsConfig(t),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return layout
+}
+
+func TestGitopsModuleStateFromCheckoutFindsApplication(t *testing.T) {
+	layout := testModuleLayout(t)
+
+	tests := []struct {
+		name            string
+		files           map[string]string
+		exists          bool
+		applicationFile string
+	}{
+		{
+			name:            "native layout",
+			files:           map[string]string{layout.ApplicationFile: "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: my-namespace-my-module\n"},
+			exists:          true,
+			applicationFile: layout.ApplicationFile,
+		},
+		{
+			name:            "application matched by name",
+			files:           map[string]string{"argocd/1-infrastructure/cluster/default/base/my-namespace/my-module.yaml": "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: my-namespace-my-module\n"},
+			exists:          true,
+			applicationFile: "argocd/1-infrastructure/cluster/default/base/my-namespace/my-module.yaml",
+		},
+		{
+			name:            "application matched by payload path",
+			files:           map[string]string{"argocd/1-infrastructure/cluster/default/base/my-module.yml": "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: my-module\nspec:\n  source:\n    path: payload/1-infrastructure/namespace/my-namespace/my-module/\n"},
+			exists:          true,
+			applicationFile: "argocd/1-infrastructure/cluster/default/base/my-module.yml",
+		},
+		{
+			name:            "payload only",
+			files:           map[string]string{"payload/1-infrastructure/namespace/my-namespace/my-module/values.yaml": "replicas: 1\n"},
+			exists:          true,
+			applicationFile: layout.ApplicationFile,
+		},
+		{
+			name: "other modules",
+			files: map[string]string{
+				"argocd/1-infrastructure/cluster/default/base/my-namespace-other.yaml":          "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: my-namespace-other\n",
+				"argocd/1-infrastructure/cluster/default/base/kustomization.yaml":               "resources:\n- my-namespace-other.yaml\n",
+				"argocd/2-services/cluster/default/base/my-namespace-my-module.yaml":            "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: my-namespace-my-module\n",
+				"payload/1-infrastructure/namespace/my-namespace/other/values.yaml":             "replicas: 1\n",
+				"argocd/1-infrastructure/cluster/default/base/my-namespace-my-module.json.orig": "kind: Application\n",
+			},
+			exists:          false,
+			applicationFile: layout.ApplicationFile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repoDir := t.TempDir()
+			for path, contents := range test.files {
+				writeTestFile(t, repoDir, path, contents)
+			}
+
+			state, err := gitopsModuleStateFromCheckout(layout, repoDir, repoDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if state.Exists != test.exists {
+				t.Errorf("expected exists %v, got %v", test.exists, state.Exists)
+			}
+			if state.ApplicationFile != filepath.FromSlash(test.applicationFile) {
+				t.Errorf("expected application file %s, got %s", test.applicationFile, state.ApplicationFile)
+			}
+			if state.Exists && len(state.Digest) == 0 {
+				t.Error("expected a digest of the module")
+			}
+		})
+	}
+}
@@ -7,18 +7,41 @@ import (
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"os"
-	"os/exec"
-	"path/filepath"
 )
 
-func readGitopsMetadata(ctx context.Context, binDir string, gitopsConfig GitopsMetadataConfig) (*GitopsMetadata, error) {
+func readGitopsMetadata(ctx context.Context, config *ProviderConfig, gitopsConfig GitopsMetadataConfig) (*GitopsMetadata, error) {
 
-	// this should be replaced with the actual git user
-	username := "cloudnativetoolkit"
+	unlock, err := lockGitopsRepos(ctx, gitopsConfig.Config, gitopsConfig.Branch)
+	if err != nil {
+		return nil, err
+	}
 
-	gitopsMutexKV.Lock(username)
+	defer unlock()
 
-	defer gitopsMutexKV.Unlock(username)
+	if config.Engine != "native" {
+		return readGitopsMetadataIgc(ctx, config.Executor, gitopsConfig)
+	}
+
+	var result *GitopsMetadata
+	err = retryTransient(ctx, config.Retry, "gitops-metadata-get", func() error {
+		var nativeErr error
+		result, nativeErr = readGitopsMetadataNative(ctx, gitopsConfig)
+
+		return nativeErr
+	})
+	if err != nil && ctx.Err() != nil {
+		err = commandContextError(ctx, "gitops-metadata-get")
+	}
+
+	return result, err
+}
+
+func readGitopsMetadataIgc(ctx context.Context, executor Executor, gitopsConfig GitopsMetadataConfig) (*GitopsMetadata, error) {
+	outputFile, cleanup, err := commandOutputFile("gitops-metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
 
 	tflog.Info(ctx, fmt.Sprintf("Retrieving gitops metadata: serverName=%s", gitopsConfig.ServerName))
 
@@ -26,7 +49,7 @@ func readGitopsMetadata(ctx context.Context, binDir string, gitopsConfig GitopsM
 		"gitops-metadata-get",
 		"--branch", gitopsConfig.Branch,
 		"--serverName", gitopsConfig.ServerName,
-		"--output", "jsonfile=./output.json"}
+		"--output", "jsonfile=" + outputFile}
 
 	if len(gitopsConfig.CaCert) > 0 {
 		args = append(args, "--caCert", gitopsConfig.CaCert)
@@ -35,59 +58,29 @@ func readGitopsMetadata(ctx context.Context, binDir string, gitopsConfig GitopsM
 		args = append(args, "--debug", gitopsConfig.Debug)
 	}
 
-	cmd := exec.Command(filepath.Join(binDir, "igc"), args...)
+	var outb bytes.Buffer
 
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
-
-	updatedEnv := append(os.Environ(), "GIT_CREDENTIALS="+gitopsConfig.Credentials)
-	updatedEnv = append(updatedEnv, "GITOPS_CONFIG="+gitopsConfig.Config)
-	updatedEnv = append(updatedEnv, "KUBECONFIG="+gitopsConfig.KubeConfigPath)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
-
-	logEnvironment(ctx, &updatedEnv)
-
-	cmd.Env = updatedEnv
-
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error starting command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
-		return nil, err
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
+	err = executor.Execute(ctx, CommandRequest{
+		Name:   "igc",
+		Args:   args,
+		Env:    append(gitopsEnv(gitopsConfig.Credentials, gitopsConfig.Config), "KUBECONFIG="+gitopsConfig.KubeConfigPath),
+		Stdout: &outb,
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	outText := outb.String()
 	if len(outText) > 0 {
-		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", outText))
-	}
-
-	errText := errb.String()
-	if len(errText) > 0 {
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errText))
+		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", redact(outText)))
 	}
 
-	dat, err := os.ReadFile("./output.json")
+	dat, err := os.ReadFile(outputFile)
 	if err != nil {
 		return nil, err
 	}
 
-	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops metadata: %s", string(dat)))
+	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops metadata: %s", redact(string(dat))))
 
 	gitopsMetadata := GitopsMetadata{}
 	err = json.Unmarshal(dat, &gitopsMetadata)
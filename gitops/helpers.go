@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"regexp"
+	"sort"
 )
 
 func getNameInput(d *schema.ResourceData) string {
@@ -128,26 +128,26 @@ type GitopsMetadataConfig struct {
 }
 
 type GitopsMetadataCluster struct {
-	DefaultIngressSubdomain string
-	DefaultIngressSecret    string
-	KubeVersion             string
-	OpenShiftVersion        string
-	Type                    string
-	OperatorNamespace       string
-	GitopsNamespace         string
+	DefaultIngressSubdomain string `yaml:"defaultIngressSubdomain"`
+	DefaultIngressSecret    string `yaml:"defaultIngressSecret"`
+	KubeVersion             string `yaml:"kubeVersion"`
+	OpenShiftVersion        string `yaml:"openShiftVersion"`
+	Type                    string `yaml:"type"`
+	OperatorNamespace       string `yaml:"operatorNamespace"`
+	GitopsNamespace         string `yaml:"gitopsNamespace"`
 }
 
 type GitopsMetadataPackage struct {
-	PackageName            string
-	CatalogSource          string
-	CatalogSourceNamespace string
-	DefaultChannel         string
-	Publisher              string
+	PackageName            string `yaml:"packageName"`
+	CatalogSource          string `yaml:"catalogSource"`
+	CatalogSourceNamespace string `yaml:"catalogSourceNamespace"`
+	DefaultChannel         string `yaml:"defaultChannel"`
+	Publisher              string `yaml:"publisher"`
 }
 
 type GitopsMetadata struct {
-	Cluster  GitopsMetadataCluster
-	Packages []GitopsMetadataPackage
+	Cluster  GitopsMetadataCluster   `yaml:"cluster"`
+	Packages []GitopsMetadataPackage `yaml:"packages"`
 }
 
 func interfacesToStrings(list []interface{}) []string {
@@ -167,55 +167,50 @@ func interfacesToStrings(list []interface{}) []string {
 	return result
 }
 
-func stringsToInterfaces(sVal *[]string) []interface{} {
-	if sVal != nil {
-		result := make([]interface{}, len(*sVal), len(*sVal))
-
-		for i, val := range *sVal {
-			result[i] = val
-		}
-
-		return result
+func interfacesToStringMap(values map[string]interface{}) map[string]string {
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key], _ = value.(string)
 	}
 
-	return make([]interface{}, 0)
+	return result
 }
 
-func logEnvironment(ctx context.Context, env *[]string) {
-	newEnv := *removeItem(env, "^GIT_CREDENTIALS")
-
-	if len(*env) != len(newEnv) {
-		newEnv = append(newEnv, "GIT_CREDENTIALS=**redacted**")
+func stringMapToInterfaces(values map[string]string) map[string]interface{} {
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		result[key] = value
 	}
 
-	tflog.Debug(ctx, fmt.Sprintf("Environment: %v", newEnv))
+	return result
 }
 
-func findItem(env *[]string, match string) int {
-	r, _ := regexp.Compile(match)
-	for i := 0; i < len(*env); i++ {
-		if r.MatchString((*env)[i]) {
-			return i
-		}
+// sortedKeys returns the keys of the map in sorted order
+func sortedKeys[V any](values map[string]V) []string {
+	result := make([]string, 0, len(values))
+	for key := range values {
+		result = append(result, key)
 	}
 
-	return -1
-}
-
-func removeItem(env *[]string, match string) *[]string {
-	pos := findItem(env, match)
+	sort.Strings(result)
 
-	if pos == -1 {
-		return env
-	}
+	return result
+}
 
-	result := make([]string, len(*env) - 1)
+func stringsToInterfaces(sVal *[]string) []interface{} {
+	if sVal != nil {
+		result := make([]interface{}, len(*sVal), len(*sVal))
 
-	for i := 0; i < len(*env); i++ {
-		if i != pos {
-			result = append(result, (*env)[i])
+		for i, val := range *sVal {
+			result[i] = val
 		}
+
+		return result
 	}
 
-	return &result
-}
\ No newline at end of file
+	return make([]interface{}, 0)
+}
+
+func logEnvironment(ctx context.Context, env *[]string) {
+	tflog.Debug(ctx, fmt.Sprintf("Environment: %v", redactEnv(*env)))
+}
@@ -0,0 +1,180 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+)
+
+func gitRepoUrl(host string, org string, project string, repo string) string {
+	if len(project) > 0 {
+		return fmt.Sprintf("https://%s/%s/%s/_git/%s", host, org, project, repo)
+	}
+
+	return fmt.Sprintf("https://%s/%s/%s", host, org, repo)
+}
+
+func gitRepoSlug(url string) string {
+	result := strings.TrimPrefix(url, "https://")
+
+	return strings.TrimPrefix(result, "http://")
+}
+
+// providerRepoUrl returns the url of the gitops repo configured in the provider block. Imported
+// resources are looked up in this repo.
+func providerRepoUrl(config *ProviderConfig) (string, error) {
+	gitConfig := config.GitConfig
+
+	if len(gitConfig.Host) == 0 || len(gitConfig.Org) == 0 || len(config.Repo) == 0 {
+		return "", errors.New("the host, org and repo of the provider must be configured to import gitops resources")
+	}
+
+	return gitRepoUrl(gitConfig.Host, gitConfig.Org, gitConfig.Project, config.Repo), nil
+}
+
+// defaultGitopsConfig builds the gitops config for the standard layout of a repo initialized by
+// gitops_repo
+func defaultGitopsConfig(url string, serverName string) GitopsConfigResult {
+	repo := gitRepoSlug(url)
+
+	layer := func(name string) LayerConfig {
+		return LayerConfig{
+			ArgocdConfig: ArgocdConfig{
+				Project: name,
+				Repo:    repo,
+				Url:     url,
+				Path:    "argocd/" + name,
+			},
+			Payload: PayloadConfig{
+				Repo: repo,
+				Url:  url,
+				Path: "payload/" + name,
+			},
+		}
+	}
+
+	bootstrap := BootstrapConfig{
+		ArgocdConfig: ArgocdConfig{
+			Project: "0-bootstrap",
+			Repo:    repo,
+			Url:     url,
+			Path:    "argocd/0-bootstrap/cluster/" + serverName,
+		},
+	}
+
+	return GitopsConfigResult{
+		Bootstrap:      bootstrap,
+		Boostrap:       bootstrap,
+		Infrastructure: layer("1-infrastructure"),
+		Services:       layer("2-services"),
+		Applications:   layer("3-applications"),
+	}
+}
+
+// parseImportId splits the import id into the colon separated parts described by format
+func parseImportId(id string, format string) ([]string, error) {
+	expected := strings.Split(format, ":")
+	parts := strings.Split(id, ":")
+
+	if len(parts) != len(expected) {
+		return nil, fmt.Errorf("unexpected format of import id (%s), expected %s", id, format)
+	}
+
+	for i, part := range parts {
+		if len(part) == 0 {
+			return nil, fmt.Errorf("%s missing from import id (%s), expected %s", expected[i], id, format)
+		}
+	}
+
+	return parts, nil
+}
+
+// importLookupConfig returns the gitops config and credentials used to look up an imported resource
+// in the repo configured in the provider block, using the standard repo layout. They are not written
+// to the state, where they would hold the provider token and differ from any configuration that
+// does not use the standard layout, so config and credentials must come from the configuration.
+func importLookupConfig(config *ProviderConfig, serverName string) (string, string, error) {
+	url, err := providerRepoUrl(config)
+	if err != nil {
+		return "", "", err
+	}
+
+	gitopsConfigJson, err := toJson(defaultGitopsConfig(url, serverName))
+	if err != nil {
+		return "", "", err
+	}
+
+	credentialsJson, err := toJson([]GitCredential{{
+		Url:      url,
+		Repo:     gitRepoSlug(url),
+		Username: config.GitConfig.Username,
+		Token:    config.GitConfig.Token,
+	}})
+	if err != nil {
+		return "", "", err
+	}
+
+	return gitopsConfigJson, credentialsJson, nil
+}
+
+func setResourceValues(d *schema.ResourceData, values map[string]interface{}) error {
+	for key, value := range values {
+		err := d.Set(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setImportDefaults sets the schema defaults that terraform does not apply to imported resources
+func setImportDefaults(d *schema.ResourceData, resourceSchema map[string]*schema.Schema) error {
+	for key, value := range resourceSchema {
+		if value.Default == nil {
+			continue
+		}
+
+		err := d.Set(key, value.Default)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importGitopsModule populates the resource from the import id and the provider config and checks
+// that the module exists in the gitops repo configured in the provider block
+func importGitopsModule(ctx context.Context, d *schema.ResourceData, config *ProviderConfig, resource *schema.Resource, moduleConfig GitopsModuleConfig) (*GitopsModuleState, error) {
+	err := setImportDefaults(d, resource.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.Set("branch", config.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	moduleConfig.Config, moduleConfig.Credentials, err = importLookupConfig(config, moduleConfig.ServerName)
+	if err != nil {
+		return nil, err
+	}
+
+	moduleConfig.Branch = getBranchInput(d)
+	moduleConfig.CaCert = config.GitConfig.CaCertFile
+
+	state, err := readGitopsModuleState(ctx, moduleConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if !state.Exists {
+		return nil, fmt.Errorf("ArgoCD application %s not found in gitops repo", state.ApplicationFile)
+	}
+
+	return state, nil
+}
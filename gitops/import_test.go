@@ -0,0 +1,154 @@
+package gitops
+
+import (
+	"context"
+	"encoding/pem"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"net/http/cgi"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// applyTestConfig plans the config against the state and applies the plan like terraform does
+func applyTestConfig(t *testing.T, resource *schema.Resource, state *terraform.InstanceState, raw map[string]interface{}, m interface{}) (*terraform.InstanceState, *terraform.InstanceDiff) {
+	t.Helper()
+
+	diff, err := resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newState, diags := resource.Apply(context.Background(), state, diff, m)
+	assertNoErrors(t, diags)
+
+	return newState, diff
+}
+
+// testHttpsGitRepo serves a local bare repo over https with git http-backend as the gitops repo of
+// the provider. It returns the file:// url of the bare repo and the provider config.
+func testHttpsGitRepo(t *testing.T) (string, *ProviderConfig) {
+	t.Helper()
+
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is needed to serve the gitops repo over https")
+	}
+
+	root := t.TempDir()
+
+	err = os.MkdirAll(filepath.Join(root, "org"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bareUrl := testBareRepo(t, "gitops")
+
+	err = os.Symlink(strings.TrimPrefix(bareUrl, "file://"), filepath.Join(root, "org", "gitops"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewTLSServer(&cgi.Handler{
+		Path: gitPath,
+		Args: []string{"http-backend"},
+		Env: []string{
+			"GIT_PROJECT_ROOT=" + root,
+			"GIT_HTTP_EXPORT_ALL=1",
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.receivepack",
+			"GIT_CONFIG_VALUE_0=true",
+		},
+	})
+	t.Cleanup(server.Close)
+
+	caCertFile := filepath.Join(t.TempDir(), "ca.crt")
+	err = os.WriteFile(caCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	providerConfig := testNativeProviderConfig()
+	providerConfig.GitConfig = &GitConfigValues{
+		Host:       strings.TrimPrefix(server.URL, "https://"),
+		Org:        "org",
+		Username:   "admin",
+		Token:      "gitops-token",
+		CaCertFile: caCertFile,
+	}
+	providerConfig.Repo = "gitops"
+	providerConfig.Branch = "main"
+
+	return bareUrl, providerConfig
+}
+
+func TestResourceGitopsModuleImportPlan(t *testing.T) {
+	bareUrl, providerConfig := testHttpsGitRepo(t)
+	resource := resourceGitopsModule()
+
+	gitopsConfig, credentials, err := importLookupConfig(providerConfig, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := map[string]interface{}{
+		"name":               "my-module",
+		"namespace":          "my-namespace",
+		"layer":              "infrastructure",
+		"config":             gitopsConfig,
+		"credentials":        credentials,
+		"helm_repo_url":      "https://charts.example.com",
+		"helm_chart":         "my-chart",
+		"helm_chart_version": "1.0.0",
+	}
+
+	// the module exists in the gitops repo before it is imported
+	_, _ = applyTestConfig(t, resource, nil, raw, providerConfig)
+	commits := len(testCommitMessages(t, bareUrl))
+
+	d := resource.Data(&terraform.InstanceState{ID: "my-namespace:my-module:default:infrastructure:base"})
+	imported, err := resource.Importer.StateContext(context.Background(), d, providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, diags := resource.RefreshWithoutUpgrade(context.Background(), imported[0].State(), providerConfig)
+	assertNoErrors(t, diags)
+
+	if len(state.Attributes["repo_digest"]) == 0 || state.Attributes["repo_digest"] != state.Attributes["applied_digest"] {
+		t.Fatalf("expected the digest of the module in the repo, got applied %q and repo %q", state.Attributes["applied_digest"], state.Attributes["repo_digest"])
+	}
+
+	// config and credentials are not imported, the first plan records them and the local content
+	diff, err := resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.RequiresNew() {
+		t.Fatal("expected the imported module not to be replaced")
+	}
+	for key := range diff.Attributes {
+		if key != "config" && key != "credentials" && key != "content_digest" {
+			t.Errorf("unexpected change of %s after the import: %+v", key, diff.Attributes[key])
+		}
+	}
+
+	state, diags = resource.Apply(context.Background(), state, diff, providerConfig)
+	assertNoErrors(t, diags)
+
+	if messages := testCommitMessages(t, bareUrl); len(messages) != commits {
+		t.Errorf("expected nothing to be pushed for the imported module, got %v", messages)
+	}
+
+	diff, err = resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() {
+		t.Errorf("expected a clean plan after the import, got %v", diff.Attributes)
+	}
+}
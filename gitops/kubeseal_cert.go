@@ -0,0 +1,207 @@
+package gitops
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+	"time"
+)
+
+// sealingCertExpiryWarning is how long before the cert expires the validation starts to warn
+const sealingCertExpiryWarning = 30 * 24 * time.Hour
+
+// parseSealingCert reads the certificate of the sealed-secrets controller from the PEM data and
+// checks that it holds an RSA public key
+func parseSealingCert(cert string) (*x509.Certificate, error) {
+	rest := []byte(strings.TrimSpace(cert))
+
+	blockTypes := []string{}
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			blockTypes = append(blockTypes, block.Type)
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %v", err)
+		}
+
+		if _, ok := certificate.PublicKey.(*rsa.PublicKey); !ok {
+			return nil, fmt.Errorf("failed to parse certificate: the kubeseal cert has an %s public key but sealed secrets require an RSA key", certificate.PublicKeyAlgorithm)
+		}
+
+		return certificate, nil
+	}
+
+	if len(blockTypes) > 0 {
+		return nil, fmt.Errorf("failed to read the kubeseal cert: expected a CERTIFICATE but found %s", strings.Join(blockTypes, ", "))
+	}
+
+	return nil, errors.New("failed to read the kubeseal cert: no certificate found in PEM data")
+}
+
+// parseSealingKey reads the public key of the sealed-secrets controller from the PEM encoded
+// certificate passed in kubeseal_cert
+func parseSealingKey(cert string) (*rsa.PublicKey, error) {
+	certificate, err := parseSealingCert(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return certificate.PublicKey.(*rsa.PublicKey), nil
+}
+
+// sealingCertFingerprint returns the SHA-256 fingerprint of the certificate in the format printed
+// by openssl x509 -fingerprint -sha256
+func sealingCertFingerprint(certificate *x509.Certificate) string {
+	digest := sha256.Sum256(certificate.Raw)
+
+	parts := make([]string, len(digest))
+	for i, b := range digest {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, ":")
+}
+
+// sealingCertExpiry describes how soon the certificate expires, or an empty string when the
+// expiry is not close
+func sealingCertExpiry(certificate *x509.Certificate, now time.Time) string {
+	notAfter := certificate.NotAfter.UTC().Format(time.RFC3339)
+
+	if now.After(certificate.NotAfter) {
+		return fmt.Sprintf("the kubeseal cert expired on %s. The sealed-secrets controller has most likely renewed its key, fetch the current cert with kubeseal --fetch-cert.", notAfter)
+	}
+
+	if certificate.NotAfter.Sub(now) < sealingCertExpiryWarning {
+		return fmt.Sprintf("the kubeseal cert expires on %s. Secrets sealed with it can still be unsealed, but new secrets should be sealed with the renewed cert.", notAfter)
+	}
+
+	return ""
+}
+
+// validateSealingCert fails on values that are not an RSA certificate and warns when the
+// certificate is close to or past its expiry. An empty value is left to the Required check.
+func validateSealingCert(value interface{}, key string) ([]string, []error) {
+	cert := value.(string)
+	if len(strings.TrimSpace(cert)) == 0 {
+		return nil, nil
+	}
+
+	certificate, err := parseSealingCert(cert)
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %v", key, err)}
+	}
+
+	if warning := sealingCertExpiry(certificate, time.Now()); len(warning) > 0 {
+		return []string{fmt.Sprintf("%s: %s", key, warning)}, nil
+	}
+
+	return nil, nil
+}
+
+// sealingCertSchema returns the computed fingerprint and not-after attributes of the cert in the
+// attribute
+func sealingCertSchema(certKey string) map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		certKey + "_fingerprint": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: fmt.Sprintf("The SHA-256 fingerprint of the certificate in %s.", certKey),
+		},
+		certKey + "_not_after": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: fmt.Sprintf("The date, in RFC 3339 format, when the certificate in %s expires.", certKey),
+		},
+	}
+}
+
+// sealingCertValues returns the values of the attributes declared by sealingCertSchema
+func sealingCertValues(certKey string, cert string) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		certKey + "_fingerprint": "",
+		certKey + "_not_after":   "",
+	}
+
+	if len(strings.TrimSpace(cert)) == 0 {
+		return result, nil
+	}
+
+	certificate, err := parseSealingCert(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	result[certKey+"_fingerprint"] = sealingCertFingerprint(certificate)
+	result[certKey+"_not_after"] = certificate.NotAfter.UTC().Format(time.RFC3339)
+
+	return result, nil
+}
+
+// setSealingCertValues sets the fingerprint and not-after attributes of the cert
+func setSealingCertValues(d *schema.ResourceData, certKey string) error {
+	values, err := sealingCertValues(certKey, d.Get(certKey).(string))
+	if err != nil {
+		return err
+	}
+
+	return setResourceValues(d, values)
+}
+
+// sealingCertKeys returns the names of the attributes declared by sealingCertSchema
+func sealingCertKeys(certKey string) []string {
+	return []string{certKey + "_fingerprint", certKey + "_not_after"}
+}
+
+// customizeSealingCertDiff plans the fingerprint and not-after attributes of the cert so they are
+// known before the apply
+func customizeSealingCertDiff(d *schema.ResourceDiff, certKey string) error {
+	if !d.NewValueKnown(certKey) {
+		return setNewComputed(d, sealingCertKeys(certKey)...)
+	}
+
+	return setNewSealingCertValues(d, certKey, d.Get(certKey).(string))
+}
+
+// setNewSealingCertValues plans the fingerprint and not-after attributes of the cert
+func setNewSealingCertValues(d *schema.ResourceDiff, certKey string, cert string) error {
+	values, err := sealingCertValues(certKey, cert)
+	if err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		if d.Get(key) == value {
+			continue
+		}
+
+		err = d.SetNew(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withSealingCertSchema adds the attributes declared by sealingCertSchema to the schema
+func withSealingCertSchema(resourceSchema map[string]*schema.Schema, certKey string) map[string]*schema.Schema {
+	for key, value := range sealingCertSchema(certKey) {
+		resourceSchema[key] = value
+	}
+
+	return resourceSchema
+}
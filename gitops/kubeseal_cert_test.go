@@ -0,0 +1,119 @@
+package gitops
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed PEM certificate for the public key of the signer that
+// expires at notAfter
+func testCertificate(t *testing.T, signer crypto.Signer, notAfter time.Time) string {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    notAfter.Add(-365 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}))
+}
+
+func TestValidateSealingCert(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    string
+		err     string
+		warning string
+	}{
+		{name: "valid", cert: testCertificate(t, rsaKey, time.Now().Add(365*24*time.Hour))},
+		{name: "empty", cert: " \n"},
+		{name: "public key block", cert: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey})), err: "expected a CERTIFICATE but found PUBLIC KEY"},
+		{name: "not pem", cert: "sealed-secrets", err: "no certificate found in PEM data"},
+		{name: "ecdsa key", cert: testCertificate(t, ecdsaKey, time.Now().Add(365*24*time.Hour)), err: "has an ECDSA public key but sealed secrets require an RSA key"},
+		{name: "expired", cert: testCertificate(t, rsaKey, time.Now().Add(-time.Hour)), warning: "the kubeseal cert expired on"},
+		{name: "expires within 30 days", cert: testCertificate(t, rsaKey, time.Now().Add(10*24*time.Hour)), warning: "the kubeseal cert expires on"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warnings, errs := validateSealingCert(test.cert, "kubeseal_cert")
+
+			if len(test.err) > 0 {
+				if len(errs) != 1 || !strings.Contains(errs[0].Error(), test.err) || !strings.HasPrefix(errs[0].Error(), "kubeseal_cert: ") {
+					t.Errorf("expected an error containing %q, got %v", test.err, errs)
+				}
+			} else if len(errs) > 0 {
+				t.Errorf("expected no errors, got %v", errs)
+			}
+
+			if len(test.warning) > 0 {
+				if len(warnings) != 1 || !strings.Contains(warnings[0], test.warning) {
+					t.Errorf("expected a warning containing %q, got %v", test.warning, warnings)
+				}
+			} else if len(warnings) > 0 {
+				t.Errorf("expected no warnings, got %v", warnings)
+			}
+		})
+	}
+}
+
+func TestSealingCertValues(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notAfter := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	values, err := sealingCertValues("kubeseal_cert", testCertificate(t, key, notAfter))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the format printed by openssl x509 -fingerprint -sha256
+	fingerprint := values["kubeseal_cert_fingerprint"].(string)
+	if !regexp.MustCompile(`^[0-9A-F]{2}(:[0-9A-F]{2}){31}$`).MatchString(fingerprint) {
+		t.Errorf("unexpected fingerprint format %q", fingerprint)
+	}
+	if values["kubeseal_cert_not_after"] != "2030-01-02T03:04:05Z" {
+		t.Errorf("unexpected not after %v", values["kubeseal_cert_not_after"])
+	}
+
+	values, err = sealingCertValues("kubeseal_cert", "")
+	if err != nil || values["kubeseal_cert_fingerprint"] != "" || values["kubeseal_cert_not_after"] != "" {
+		t.Errorf("expected empty values without a cert, got %v %v", values, err)
+	}
+}
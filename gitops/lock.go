@@ -0,0 +1,109 @@
+package gitops
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// gitRepoLockKey identifies the branch of a git repo. Changes pushed to the same branch are
+// serialized while different repos and branches proceed in parallel.
+func gitRepoLockKey(url string, branch string) string {
+	return normalizeGitUrl(url) + "#" + branch
+}
+
+// gitopsConfigLockKeys returns the sorted lock keys of every repo referenced by the gitops config.
+// A config that cannot be parsed is locked on a digest of its contents.
+func gitopsConfigLockKeys(config string, branch string) []string {
+	gitopsConfig, err := parseGitopsConfig(config)
+
+	urls := []string{}
+	if err == nil {
+		urls = append(urls, gitopsConfig.Bootstrap.ArgocdConfig.Url)
+
+		for _, layer := range []LayerConfig{gitopsConfig.Infrastructure, gitopsConfig.Services, gitopsConfig.Applications} {
+			urls = append(urls, layer.ArgocdConfig.Url, layer.Payload.Url)
+		}
+	}
+
+	keys := map[string]bool{}
+	for _, url := range urls {
+		if len(url) > 0 {
+			keys[gitRepoLockKey(url, branch)] = true
+		}
+	}
+
+	if len(keys) == 0 {
+		digest := sha256.Sum256([]byte(config))
+
+		return []string{"config:" + hex.EncodeToString(digest[:8]) + "#" + branch}
+	}
+
+	result := make([]string, 0, len(keys))
+	for key := range keys {
+		result = append(result, key)
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// lockGitopsRepos locks every repo referenced by the gitops config, always in the same order so
+// two operations sharing a repo cannot deadlock. The returned function releases the locks. The wait
+// for a lock ends with an error when the context is done, with every lock taken so far released.
+func lockGitopsRepos(ctx context.Context, config string, branch string) (func(), error) {
+	keys := gitopsConfigLockKeys(config, branch)
+
+	unlocks := make([]func(), 0, len(keys))
+	unlock := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+
+	for _, key := range keys {
+		unlockKey, err := lockGitRepo(ctx, key)
+		if err != nil {
+			unlock()
+			return nil, err
+		}
+
+		unlocks = append(unlocks, unlockKey)
+	}
+
+	return unlock, nil
+}
+
+// lockGitRepo locks the key, waiting until the lock is free or the context is done. When the
+// context ends first, the lock is released as soon as the pending wait acquires it.
+func lockGitRepo(ctx context.Context, key string) (func(), error) {
+	locked := make(chan struct{})
+	go func() {
+		gitopsMutexKV.Lock(key)
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return func() { gitopsMutexKV.Unlock(key) }, nil
+	case <-ctx.Done():
+		go func() {
+			<-locked
+			gitopsMutexKV.Unlock(key)
+		}()
+
+		return nil, lockContextError(ctx, key)
+	}
+}
+
+// lockContextError describes why the wait for the lock was stopped
+func lockContextError(ctx context.Context, key string) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out waiting for the lock on %s, another operation on the repo is still running", key)
+	}
+
+	return fmt.Errorf("the wait for the lock on %s was cancelled", key)
+}
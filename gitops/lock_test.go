@@ -0,0 +1,77 @@
+package gitops
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitopsConfigLockKeys(t *testing.T) {
+	jsonConfig := `{"boostrap": {"argocd-config": {"url": "https://github.com/org/bootstrap"}}, "infrastructure": {"argocd-config": {"url": "https://github.com/org/argocd.git"}, "payload": {"url": "https://github.com/Org/payload"}}}`
+	yamlConfig := `boostrap:
+  argocd-config:
+    url: https://github.com/org/bootstrap
+infrastructure:
+  argocd-config:
+    url: https://github.com/org/argocd.git
+  payload:
+    url: https://github.com/Org/payload
+`
+
+	expected := []string{"github.com/org/argocd#main", "github.com/org/bootstrap#main", "github.com/org/payload#main"}
+
+	for name, config := range map[string]string{"jsonencode": jsonConfig, "yamlencode": yamlConfig} {
+		t.Run(name, func(t *testing.T) {
+			keys := gitopsConfigLockKeys(config, "main")
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("expected lock keys %v, got %v", expected, keys)
+			}
+
+			// the native engine and refresh parse the config the same way the lock does
+			layout, err := gitopsModuleLayout(GitopsModuleConfig{Name: "my-module", Namespace: "my-namespace", ServerName: "default", Layer: "infrastructure", Type: "base", Config: config})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout.ArgocdConfig.Url != "https://github.com/org/argocd.git" || layout.PayloadConfig.Url != "https://github.com/Org/payload" {
+				t.Errorf("unexpected layout: %+v", layout)
+			}
+		})
+	}
+
+	keys := gitopsConfigLockKeys("not: [valid", "main")
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "config:") || !strings.HasSuffix(keys[0], "#main") {
+		t.Errorf("expected an invalid config to be locked on its digest, got %v", keys)
+	}
+}
+
+func TestLockGitopsReposTimesOut(t *testing.T) {
+	config := `{"boostrap": {"argocd-config": {"url": "https://github.com/org/lock-bootstrap"}}, "infrastructure": {"argocd-config": {"url": "https://github.com/org/lock-argocd"}, "payload": {"url": "https://github.com/org/lock-payload"}}}`
+
+	// another operation holds the lock on the second repo
+	unlockBootstrap, err := lockGitRepo(context.Background(), gitRepoLockKey("https://github.com/org/lock-bootstrap", "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = lockGitopsRepos(ctx, config, "main")
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for the lock on github.com/org/lock-bootstrap#main") {
+		t.Fatalf("expected the wait for the lock to time out, got %v", err)
+	}
+
+	// the lock taken on the first repo was released and the pending wait releases the second one
+	unlockBootstrap()
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	unlock, err := lockGitopsRepos(ctx, config, "main")
+	if err != nil {
+		t.Fatalf("expected the locks to be free, got %v", err)
+	}
+	unlock()
+}
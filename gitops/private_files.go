@@ -0,0 +1,101 @@
+package gitops
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// privateTempDir creates a temp dir that only the current user can read. The returned function
+// overwrites and removes the files in the dir.
+func privateTempDir(prefix string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", prefix+"-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	// MkdirTemp already uses 0700, the mode is set explicitly so it does not depend on the platform
+	err = os.Chmod(dir, 0700)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	cleanup := func() {
+		_ = secureRemoveAll(dir)
+	}
+
+	return dir, cleanup, nil
+}
+
+// secureRemoveAll overwrites the contents of the regular files below the dir with zeros before the
+// dir is removed, so the plain text is not left in the freed blocks. A missing dir is not an error.
+func secureRemoveAll(dir string) error {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		return overwriteFile(path)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.RemoveAll(dir)
+}
+
+func overwriteFile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(make([]byte, info.Size()))
+	if err != nil {
+		return err
+	}
+
+	return file.Sync()
+}
+
+// preparePrivateDir creates the dir that receives plain text values, readable only by the current
+// user, when forbid_plaintext_on_disk is set. A dir that already exists must not be readable by other
+// users either.
+func (c *ProviderConfig) preparePrivateDir(description string, dir string) error {
+	if !c.ForbidPlaintextOnDisk {
+		return nil
+	}
+
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	return checkPrivateDir(description, dir)
+}
+
+// checkPrivateDir fails when other users can read the dir that receives plain text values
+func checkPrivateDir(description string, dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s would be written to %s, which other users can read, and forbid_plaintext_on_disk is set", description, dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package gitops
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreparePrivateDir(t *testing.T) {
+	newDir := filepath.Join(t.TempDir(), ".tmp", "gitops-init")
+
+	err := (&ProviderConfig{}).preparePrivateDir("the git token", newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(newDir) {
+		t.Error("expected the dir not to be created without forbid_plaintext_on_disk")
+	}
+
+	config := &ProviderConfig{ForbidPlaintextOnDisk: true}
+
+	err = config.preparePrivateDir("the git token", newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(newDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("expected the dir to be private, got %v", info.Mode().Perm())
+	}
+
+	readableDir := t.TempDir()
+	err = os.Chmod(readableDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = config.preparePrivateDir("the git token", readableDir)
+	if err == nil || !strings.Contains(err.Error(), "which other users can read") {
+		t.Errorf("expected an error for a dir other users can read, got %v", err)
+	}
+}
+
+func TestProcessGitopsRepoForbidsReadableTmpDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	err := os.Chmod(tmpDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := &RecordingExecutor{}
+	providerConfig := testProviderConfig(executor)
+	providerConfig.ForbidPlaintextOnDisk = true
+
+	repoConfig := GitopsRepoConfig{
+		Host:       "github.com",
+		Org:        "org",
+		Repo:       "gitops",
+		Username:   "admin",
+		Token:      "gitops-token",
+		Branch:     "main",
+		ServerName: "default",
+		TmpDir:     tmpDir,
+	}
+
+	_, err = processGitopsRepo(context.Background(), providerConfig, repoConfig, false)
+	if err == nil || !strings.Contains(err.Error(), "forbid_plaintext_on_disk") {
+		t.Errorf("expected the readable tmp_dir to be rejected, got %v", err)
+	}
+
+	if requests := executor.Requests(); len(requests) > 0 {
+		t.Errorf("expected igc not to run, got %v", requests)
+	}
+
+	// a repo created with a tmp_dir left readable by earlier versions can still be deleted
+	_, _ = processGitopsRepo(context.Background(), providerConfig, repoConfig, true)
+	if requests := executor.Requests(); len(requests) != 1 || !strings.Contains(strings.Join(requests[0].Args, " "), "--delete") {
+		t.Errorf("expected igc to delete the repo, got %v", requests)
+	}
+
+	// a private tmp_dir is accepted
+	repoConfig.TmpDir = filepath.Join(t.TempDir(), "gitops-init")
+
+	_, _ = processGitopsRepo(context.Background(), providerConfig, repoConfig, false)
+	if requests := executor.Requests(); len(requests) != 2 {
+		t.Errorf("expected igc to run with a private tmp_dir, got %v", requests)
+	}
+}
+
+// testChdir changes the working dir for the duration of the test
+func testChdir(t *testing.T, dir string) {
+	t.Helper()
+
+	workDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(workDir)
+	})
+
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateCaCertFileIsPrivate(t *testing.T) {
+	// the ca cert is public data, so a working dir that other users can read is accepted
+	readableDir := t.TempDir()
+	err := os.Chmod(readableDir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testChdir(t, readableDir)
+
+	caCertFile, err := createCaCertFile(b64.StdEncoding.EncodeToString([]byte("-----BEGIN CERTIFICATE-----\n")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the ca cert file to be readable only by the current user, got %v", info.Mode().Perm())
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"os"
 	"path/filepath"
 	mutexkv "terraform-provider-gitops/mutex"
+	"time"
 )
 
 var gitopsMutexKV = mutexkv.NewMutexKV()
@@ -20,8 +22,8 @@ func Provider() *schema.Provider {
 		Schema: map[string]*schema.Schema{
 			"bin_dir": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The directory containing the igc binary used to interact with the gitops repo.",
+				Optional:    true,
+				Description: "The directory containing the igc binary used to interact with the gitops repo. Required with git_engine = \"igc\". With the native engine it is only used to delete a gitops_repo created with the igc cli.",
 			},
 			"repo": {
 				Type:        schema.TypeString,
@@ -133,6 +135,63 @@ func Provider() *schema.Provider {
 				Description: "The default/fallback file containing the ca certificate used to sign the self-signed certificate used by the git server, if applicable.",
 				DefaultFunc: schema.EnvDefaultFunc("GITOPS_CA_CERT_FILE", ""),
 			},
+			"git_engine": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The engine used to read and write the gitops repo. `igc` runs the igc cli from bin_dir and `native` clones, commits and pushes the repo in-process without the cli. Not supported with `native`: the create of `gitops_repo` and the create of `gitops_metadata`, which fail with an error and need the igc engine.",
+				DefaultFunc:  schema.EnvDefaultFunc("GITOPS_ENGINE", "igc"),
+				ValidateFunc: validation.StringInSlice([]string{"igc", "native"}, false),
+			},
+			"retry_max_attempts": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The number of attempts made when pushing to the gitops repo fails with a transient error (rejected push, rate limit, network reset).",
+				DefaultFunc:  schema.EnvDefaultFunc("GITOPS_RETRY_MAX_ATTEMPTS", 5),
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"retry_max_wait": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The maximum wait between two attempts, e.g. 30s. The wait grows exponentially from 1s with random jitter.",
+				DefaultFunc:  schema.EnvDefaultFunc("GITOPS_RETRY_MAX_WAIT", "30s"),
+				ValidateFunc: validateDuration,
+			},
+			"timeouts": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "The default timeouts of the resource operations, e.g. 10m. The timeouts block of a resource takes precedence.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"create": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDuration,
+						},
+						"read": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDuration,
+						},
+						"update": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDuration,
+						},
+						"delete": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateDuration,
+						},
+					},
+				},
+			},
+			"forbid_plaintext_on_disk": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Fail any operation that would write secret values to disk in plain text in a dir that other users can read, i.e. the clone of the repo that `igc gitops-init` makes in the tmp_dir of `gitops_repo` when the repo is created.",
+				DefaultFunc: schema.EnvDefaultFunc("GITOPS_FORBID_PLAINTEXT_ON_DISK", false),
+			},
 			"lock": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -151,10 +210,11 @@ func Provider() *schema.Provider {
 			"gitops_service_account": resourceGitopsServiceAccount(),
 			"gitops_seal_secrets":    resourceGitopsSealSecrets(),
 			"gitops_pull_secret":     resourceGitopsPullSecret(),
+			"gitops_secret":          resourceGitopsSecret(),
 			"gitops_metadata":        resourceGitopsMetadata(),
 		},
-		DataSourcesMap:       map[string]*schema.Resource{
-			"gitops_repo_config": dataGitopsRepoConfig(),
+		DataSourcesMap: map[string]*schema.Resource{
+			"gitops_repo_config":       dataGitopsRepoConfig(),
 			"gitops_metadata_cluster":  dataGitopsMetadataCluster(),
 			"gitops_metadata_packages": dataGitopsMetadataPackages(),
 		},
@@ -172,14 +232,57 @@ type GitConfigValues struct {
 }
 
 type ProviderConfig struct {
-	BinDir     string
-	GitConfig  *GitConfigValues
-	Repo       string
-	Branch     string
-	ServerName string
-	Public     bool
-	Lock       string
-	Debug      string
+	BinDir                string
+	GitConfig             *GitConfigValues
+	Repo                  string
+	Branch                string
+	ServerName            string
+	Public                bool
+	Lock                  string
+	Debug                 string
+	Engine                string
+	Executor              Executor
+	Timeouts              map[string]time.Duration
+	Retry                 RetryConfig
+	ForbidPlaintextOnDisk bool
+}
+
+// redacted returns a copy of the config that is safe to log
+func (c GitConfigValues) redacted() GitConfigValues {
+	if len(c.Token) > 0 {
+		c.Token = redactedValue
+	}
+
+	return c
+}
+
+// redacted returns the values of the config that are safe to log
+func (c *ProviderConfig) redacted() map[string]any {
+	return map[string]any{
+		"binDir":                c.BinDir,
+		"gitConfig":             c.GitConfig.redacted(),
+		"repo":                  c.Repo,
+		"branch":                c.Branch,
+		"serverName":            c.ServerName,
+		"public":                c.Public,
+		"lock":                  c.Lock,
+		"debug":                 c.Debug,
+		"engine":                c.Engine,
+		"timeouts":              c.Timeouts,
+		"retry":                 c.Retry,
+		"forbidPlaintextOnDisk": c.ForbidPlaintextOnDisk,
+	}
+}
+
+// checkIgcEngine fails the create of the resources that are only implemented with the igc cli when
+// the native engine is selected. Delete still runs the igc cli so existing resources can be removed
+// after switching engines.
+func (c *ProviderConfig) checkIgcEngine(name string) error {
+	if c.Engine != "native" {
+		return nil
+	}
+
+	return fmt.Errorf("%s is only implemented with the igc cli and is not supported with git_engine = \"native\", use the igc engine with the igc cli in bin_dir", name)
 }
 
 func createCaCertFile(caCert string, prefix string) (string, error) {
@@ -197,7 +300,7 @@ func createCaCertFile(caCert string, prefix string) (string, error) {
 	}
 
 	d1 := []byte(decodedCaCert)
-	err = os.WriteFile(caCertFile, d1, 0666)
+	err = os.WriteFile(caCertFile, d1, 0600)
 	if err != nil {
 		return "", err
 	}
@@ -260,6 +363,7 @@ func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}
 	binDir := d.Get("bin_dir").(string)
 	lock := d.Get("lock").(string)
 	debug := d.Get("debug").(string)
+	engine := d.Get("git_engine").(string)
 
 	repo := getResourceValue(d, "repo", "")
 	branch := getResourceValue(d, "branch", "main")
@@ -269,6 +373,25 @@ func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
+	if engine == "igc" && len(binDir) == 0 {
+		return nil, diag.Errorf("bin_dir is required with git_engine = \"igc\"")
+	}
+
+	timeouts, err := loadProviderTimeouts(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	retryMaxWait, err := time.ParseDuration(d.Get("retry_max_wait").(string))
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	retry := RetryConfig{
+		MaxAttempts: d.Get("retry_max_attempts").(int),
+		MaxWait:     retryMaxWait,
+	}
+
 	gitConfig, err := loadGitConfigValues(ctx, d, "")
 	if err != nil {
 		tflog.Error(ctx, "Error loading config values", err)
@@ -283,26 +406,35 @@ func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}
 		}
 	}
 
+	registerSecret(d.Get("token").(string))
+	registerSecret(d.Get("default_token").(string))
+
 	ctx = tflog.With(ctx, "gitops_binDir", binDir)
 	ctx = tflog.With(ctx, "gitops_repo", repo)
 	ctx = tflog.With(ctx, "gitops_branch", branch)
+	ctx = tflog.With(ctx, "gitops_engine", engine)
 	ctx = tflog.With(ctx, "gitops_serverName", serverName)
-	ctx = tflog.With(ctx, "gitops_gitConfig", gitConfig)
+	ctx = tflog.With(ctx, "gitops_gitConfig", gitConfig.redacted())
 
 	tflog.Debug(ctx, "Creating GitOps provider config")
 
 	c := &ProviderConfig{
-		BinDir:     binDir,
-		GitConfig:  gitConfig,
-		Repo:       repo,
-		Branch:     branch,
-		ServerName: serverName,
-		Public:     public,
-		Lock:       lock,
-		Debug:      debug,
+		BinDir:                binDir,
+		GitConfig:             gitConfig,
+		Repo:                  repo,
+		Branch:                branch,
+		ServerName:            serverName,
+		Public:                public,
+		Lock:                  lock,
+		Debug:                 debug,
+		Engine:                engine,
+		Executor:              NewRetryExecutor(NewExecutor(binDir), retry),
+		Timeouts:              timeouts,
+		Retry:                 retry,
+		ForbidPlaintextOnDisk: d.Get("forbid_plaintext_on_disk").(bool),
 	}
 
-	tflog.Info(ctx, "Configured Gitops provider", map[string]any{"success": true, "config": c})
+	tflog.Info(ctx, "Configured Gitops provider", map[string]any{"success": true, "config": c.redacted()})
 
 	return c, diags
 }
@@ -0,0 +1,65 @@
+package gitops
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"strings"
+	"testing"
+)
+
+func TestProviderConfigureBinDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      map[string]interface{}
+		expected string
+	}{
+		{name: "igc without bin_dir", raw: map[string]interface{}{"git_engine": "igc"}, expected: "bin_dir is required"},
+		{name: "igc with bin_dir", raw: map[string]interface{}{"git_engine": "igc", "bin_dir": "/usr/local/bin"}},
+		{name: "native without bin_dir", raw: map[string]interface{}{"git_engine": "native"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := schema.TestResourceDataRaw(t, Provider().Schema, test.raw)
+
+			_, diags := providerConfigure(context.Background(), d)
+
+			if len(test.expected) == 0 {
+				assertNoErrors(t, diags)
+			} else if !diags.HasError() || !strings.Contains(diags[0].Summary, test.expected) {
+				t.Errorf("expected an error containing %q, got %v", test.expected, diags)
+			}
+		})
+	}
+}
+
+func TestNativeEngineRefusesIgcOnlyResources(t *testing.T) {
+	tests := []struct {
+		name   string
+		create func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics
+		d      *schema.ResourceData
+	}{
+		{name: "gitops_repo", create: resourceGitopsRepoCreate, d: resourceGitopsRepo().TestResourceData()},
+		{name: "gitops_metadata", create: resourceGitopsMetadataCreate, d: resourceGitopsMetadata().TestResourceData()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := &RecordingExecutor{}
+
+			providerConfig := testProviderConfig(executor)
+			providerConfig.Engine = "native"
+
+			diags := test.create(context.Background(), test.d, providerConfig)
+
+			expected := test.name + " is only implemented with the igc cli"
+			if !diags.HasError() || !strings.Contains(diags[0].Summary, expected) {
+				t.Errorf("expected an error containing %q, got %v", expected, diags)
+			}
+			if len(executor.Requests()) != 0 {
+				t.Errorf("expected no command to run, got %v", executor.Requests())
+			}
+		})
+	}
+}
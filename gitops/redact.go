@@ -0,0 +1,163 @@
+package gitops
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const redactedValue = "**redacted**"
+
+// minSecretLength keeps short values like "true" from being masked everywhere
+const minSecretLength = 4
+
+var (
+	sensitiveEnvVar = regexp.MustCompile(`(?i)(TOKEN|PASSWORD|SECRET|CREDENTIALS|KUBESEAL_CERT|PRIVATE_KEY|API_KEY)`)
+	urlUserInfo     = regexp.MustCompile(`(://[^/\s:@]+:)[^@\s/]+@`)
+)
+
+// sensitiveFlagSuffixes are the endings of the flags that take a secret value. Flags like --caCert,
+// --sealed-secrets-cert or --secret-name take a path or a name and are logged as is.
+var sensitiveFlagSuffixes = []string{"password", "passwd", "token", "apikey", "privatekey", "secret", "credentials"}
+
+// secretValues holds the secret values seen by the provider (tokens, passwords, credentials) so
+// they can be masked wherever they show up, e.g. in the output of a command
+var secretValues = &secretRegistry{values: map[string]bool{}}
+
+type secretRegistry struct {
+	lock   sync.RWMutex
+	values map[string]bool
+	sorted []string
+}
+
+func (r *secretRegistry) add(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLength {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.values[value] {
+		return
+	}
+
+	r.values[value] = true
+	r.sorted = append(r.sorted, value)
+
+	// longest first so a secret containing another one is masked as a whole
+	sort.Slice(r.sorted, func(i, j int) bool {
+		return len(r.sorted[i]) > len(r.sorted[j])
+	})
+}
+
+func (r *secretRegistry) mask(text string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, value := range r.sorted {
+		text = strings.ReplaceAll(text, value, redactedValue)
+	}
+
+	return text
+}
+
+// registerSecret adds the value to the values masked by redact
+func registerSecret(value string) {
+	secretValues.add(value)
+}
+
+// registerGitCredentials adds the tokens found in the GIT_CREDENTIALS json to the masked values
+func registerGitCredentials(credentials string) {
+	registerSecret(credentials)
+
+	gitCredentials, err := parseGitCredentials(credentials)
+	if err != nil {
+		return
+	}
+
+	for _, credential := range gitCredentials {
+		registerSecret(credential.Token)
+	}
+}
+
+// redact masks the registered secret values and the passwords embedded in urls. Every message
+// logged with output from a command goes through redact.
+func redact(text string) string {
+	text = urlUserInfo.ReplaceAllString(text, "${1}"+redactedValue+"@")
+
+	return secretValues.mask(text)
+}
+
+// redactEnv masks the value of every sensitive variable in the environment and registers the value
+// so it is masked in the rest of the output as well
+func redactEnv(env []string) []string {
+	result := make([]string, 0, len(env))
+
+	for _, entry := range env {
+		name, value, found := strings.Cut(entry, "=")
+
+		if found && sensitiveEnvVar.MatchString(name) {
+			if name == "GIT_CREDENTIALS" {
+				registerGitCredentials(value)
+			} else {
+				registerSecret(value)
+			}
+
+			result = append(result, name+"="+redactedValue)
+			continue
+		}
+
+		result = append(result, redact(entry))
+	}
+
+	return result
+}
+
+// isSensitiveFlag reports whether the flag takes a secret value, e.g. --docker-password or --token
+func isSensitiveFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+
+	name := strings.ToLower(strings.TrimLeft(arg, "-"))
+	name = strings.NewReplacer("-", "", "_", "").Replace(name)
+
+	for _, suffix := range sensitiveFlagSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactCommandLine builds the command line with the values of password, token and other secret
+// flags masked, so it can be logged and shown in diagnostics
+func redactCommandLine(name string, args []string) string {
+	result := []string{name}
+
+	redactNext := false
+	for _, arg := range args {
+		switch {
+		case redactNext:
+			registerSecret(arg)
+			arg = redactedValue
+			redactNext = false
+		case strings.HasPrefix(arg, "-") && strings.Contains(arg, "="):
+			flag, value, _ := strings.Cut(arg, "=")
+			if isSensitiveFlag(flag) {
+				registerSecret(value)
+				arg = flag + "=" + redactedValue
+			}
+		case isSensitiveFlag(arg):
+			redactNext = true
+		}
+
+		result = append(result, arg)
+	}
+
+	return redact(strings.Join(result, " "))
+}
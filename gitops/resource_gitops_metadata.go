@@ -1,24 +1,24 @@
 package gitops
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"os"
-	"os/exec"
-	"path/filepath"
 )
 
 func resourceGitopsMetadata() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsMetadataCreate,
-		ReadContext:   resourceGitopsMetadataRead,
-		UpdateContext: resourceGitopsMetadataUpdate,
-		DeleteContext: resourceGitopsMetadataDelete,
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsMetadataCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsMetadataRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsMetadataUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsMetadataDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsMetadataImport,
+		},
+		Timeouts: gitopsResourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"server_name": {
 				Type:     schema.TypeString,
@@ -48,7 +48,7 @@ func resourceGitopsMetadata() *schema.Resource {
 				Required: true,
 			},
 		},
-	}
+	})
 }
 
 func resourceGitopsMetadataCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -56,20 +56,25 @@ func resourceGitopsMetadataCreate(ctx context.Context, d *schema.ResourceData, m
 
 	config := m.(*ProviderConfig)
 
+	err := config.checkIgcEngine("gitops_metadata")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	metadataConfig := GitopsMetadataConfig{
-		KubeConfigPath: getKubeConfigPath(d),
-		Branch:         getBranchInput(d),
-		ServerName:     getServerNameInput(d),
-		Credentials:    getCredentialsInput(d),
-		Config:         getGitopsConfigInput(d),
+		KubeConfigPath:  getKubeConfigPath(d),
+		Branch:          getBranchInput(d),
+		ServerName:      getServerNameInput(d),
+		Credentials:     getCredentialsInput(d),
+		Config:          getGitopsConfigInput(d),
 		GitopsNamespace: getGitopsNamespaceInput(d),
-		CaCert:         config.GitConfig.CaCertFile,
-		Debug:          config.Debug,
+		CaCert:          config.GitConfig.CaCertFile,
+		Debug:           config.Debug,
 	}
 
-	id, err := populateGitopsMetadata(ctx, config.BinDir, metadataConfig, false)
+	id, err := populateGitopsMetadata(ctx, config.Executor, metadataConfig, false)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
@@ -84,6 +89,58 @@ func resourceGitopsMetadataRead(ctx context.Context, d *schema.ResourceData, m i
 	return diags
 }
 
+// resourceGitopsMetadataImport adopts the metadata of a cluster using the server name as the import
+// id, after checking that the gitops repo configured in the provider block holds metadata for the
+// server. The kube_config_path must be taken from the configuration.
+func resourceGitopsMetadataImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	parts, err := parseImportId(d.Id(), "serverName")
+	if err != nil {
+		return nil, err
+	}
+
+	gitopsConfig, credentials, err := importLookupConfig(config, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := readGitopsMetadata(ctx, config, GitopsMetadataConfig{
+		Branch:      config.Branch,
+		ServerName:  parts[0],
+		Credentials: credentials,
+		Config:      gitopsConfig,
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the gitops metadata of server %s: %w", parts[0], err)
+	}
+
+	if metadata.Cluster == (GitopsMetadataCluster{}) && len(metadata.Packages) == 0 {
+		return nil, fmt.Errorf("gitops metadata of server %s not found in gitops repo", parts[0])
+	}
+
+	err = setImportDefaults(d, resourceGitopsMetadata().Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.Set("branch", config.Branch)
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.Set("server_name", parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	d.SetId(uuid.New().String())
+
+	return []*schema.ResourceData{d}, nil
+}
+
 func resourceGitopsMetadataUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	return resourceGitopsMetadataRead(ctx, d, m)
 }
@@ -103,9 +160,9 @@ func resourceGitopsMetadataDelete(ctx context.Context, d *schema.ResourceData, m
 		Debug:          config.Debug,
 	}
 
-	id, err := populateGitopsMetadata(ctx, config.BinDir, metadataConfig, true)
+	id, err := populateGitopsMetadata(ctx, config.Executor, metadataConfig, true)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
@@ -113,14 +170,14 @@ func resourceGitopsMetadataDelete(ctx context.Context, d *schema.ResourceData, m
 	return diags
 }
 
-func populateGitopsMetadata(ctx context.Context, binDir string, gitopsConfig GitopsMetadataConfig, delete bool) (string, error) {
-
-	// this should be replaced with the actual git user
-	username := "cloudnativetoolkit"
+func populateGitopsMetadata(ctx context.Context, executor Executor, gitopsConfig GitopsMetadataConfig, delete bool) (string, error) {
 
-	gitopsMutexKV.Lock(username)
+	unlock, err := lockGitopsRepos(ctx, gitopsConfig.Config, gitopsConfig.Branch)
+	if err != nil {
+		return "", err
+	}
 
-	defer gitopsMutexKV.Unlock(username)
+	defer unlock()
 
 	tflog.Info(ctx, fmt.Sprintf("Provisioning gitops metadata: serverName=%s", gitopsConfig.ServerName))
 
@@ -144,67 +201,16 @@ func populateGitopsMetadata(ctx context.Context, binDir string, gitopsConfig Git
 		args = append(args, "--debug", gitopsConfig.Debug)
 	}
 
-	cmd := exec.Command(filepath.Join(binDir, "igc"), args...)
-
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
-
-	updatedEnv := append(os.Environ(), "GIT_CREDENTIALS="+gitopsConfig.Credentials)
-	updatedEnv = append(updatedEnv, "GITOPS_CONFIG="+gitopsConfig.Config)
-	updatedEnv = append(updatedEnv, "KUBECONFIG="+gitopsConfig.KubeConfigPath)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
-
-	logEnvironment(ctx, &updatedEnv)
-
-	cmd.Env = updatedEnv
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
-	}
-
-	stderr, err := cmd.StderrPipe()
+	err = executor.Execute(ctx, CommandRequest{
+		Name:  "igc",
+		Args:  args,
+		Env:   append(gitopsEnv(gitopsConfig.Credentials, gitopsConfig.Config), "KUBECONFIG="+gitopsConfig.KubeConfigPath),
+		Debug: gitopsConfig.Debug == "true",
+	})
 	if err != nil {
 		return "", err
 	}
 
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		return "", err
-	}
-
-	// read command's stdout line by line
-	in := bufio.NewScanner(stdout)
-	inErr := bufio.NewScanner(stderr)
-
-	for in.Scan() {
-		if gitopsConfig.Debug == "true" {
-			tflog.Debug(ctx, in.Text())
-		} else {
-			tflog.Info(ctx, in.Text())
-		}
-	}
-
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		return "", err
-	}
-
-	if err := in.Err(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error processing stream: %s", fmt.Sprintln(err)))
-		return "", err
-	}
-
 	var id string
 	if delete {
 		id = ""
@@ -0,0 +1,112 @@
+package gitops
+
+import (
+	"context"
+	"errors"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"os"
+	"strings"
+	"testing"
+)
+
+// metadataExecutor answers gitops-metadata-get with the given json
+func metadataExecutor(metadata string) *RecordingExecutor {
+	return &RecordingExecutor{
+		Handler: func(ctx context.Context, request CommandRequest) error {
+			for _, arg := range request.Args {
+				if strings.HasPrefix(arg, "jsonfile=") {
+					return os.WriteFile(strings.TrimPrefix(arg, "jsonfile="), []byte(metadata), 0600)
+				}
+			}
+
+			return nil
+		},
+	}
+}
+
+func testImportProviderConfig(executor Executor) *ProviderConfig {
+	providerConfig := testProviderConfig(executor)
+	providerConfig.GitConfig.Host = "github.com"
+	providerConfig.GitConfig.Org = "org"
+	providerConfig.GitConfig.Token = "provider-token"
+	providerConfig.Repo = "gitops"
+	providerConfig.Branch = "main"
+
+	return providerConfig
+}
+
+func TestResourceGitopsMetadataImportChecksRepo(t *testing.T) {
+	executor := metadataExecutor(`{"Cluster": {"Type": "ocp4", "KubeVersion": "v1.25.4"}, "Packages": []}`)
+
+	d := resourceGitopsMetadata().TestResourceData()
+	d.SetId("cluster1")
+
+	result, err := resourceGitopsMetadataImport(context.Background(), d, testImportProviderConfig(executor))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || len(result[0].Id()) == 0 || result[0].Get("server_name") != "cluster1" {
+		t.Fatalf("unexpected import result: id=%q server_name=%v", d.Id(), d.Get("server_name"))
+	}
+
+	requests := executor.Requests()
+	if len(requests) != 1 || requests[0].Args[0] != "gitops-metadata-get" {
+		t.Fatalf("expected the metadata to be read, got %v", requests)
+	}
+	if !strings.Contains(strings.Join(requests[0].Args, " "), "--serverName cluster1") {
+		t.Errorf("expected the metadata of cluster1 to be read, got %v", requests[0].Args)
+	}
+	assertEnv(t, requests[0], "GIT_CREDENTIALS", `[{"repo":"github.com/org/gitops","url":"https://github.com/org/gitops","username":"","token":"provider-token"}]`)
+}
+
+func TestResourceGitopsMetadataImportRejectsMissingMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor *RecordingExecutor
+		expected string
+	}{
+		{
+			name:     "empty metadata",
+			executor: metadataExecutor(`{"Cluster": {}, "Packages": []}`),
+			expected: "not found in gitops repo",
+		},
+		{
+			name: "failed command",
+			executor: &RecordingExecutor{Handler: func(ctx context.Context, request CommandRequest) error {
+				return errors.New("repository not found")
+			}},
+			expected: "repository not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := resourceGitopsMetadata().TestResourceData()
+			d.SetId("cluster1")
+
+			_, err := resourceGitopsMetadataImport(context.Background(), d, testImportProviderConfig(test.executor))
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error containing %q, got %v", test.expected, err)
+			}
+		})
+	}
+}
+
+func TestResourceGitopsRepoReadWarnsWhenUnreachable(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceGitopsRepo().Schema, map[string]interface{}{})
+	d.SetId("gitops-repo")
+
+	err := d.Set("url", "http://127.0.0.1:1/org/gitops")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diags := resourceGitopsRepoRead(context.Background(), d, testProviderConfig(&RecordingExecutor{}))
+	if len(diags) != 1 || diags.HasError() {
+		t.Fatalf("expected a single warning, got %v", diags)
+	}
+	if d.Id() != "gitops-repo" {
+		t.Error("expected the repo to be kept in the state")
+	}
+}
@@ -1,7 +1,6 @@
 package gitops
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
@@ -9,31 +8,40 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
-	"os"
-	"os/exec"
-	"path/filepath"
 )
 
 func resourceGitopsModule() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsModuleCreate,
-		ReadContext:   resourceGitopsModuleRead,
-		UpdateContext: resourceGitopsModuleUpdate,
-		DeleteContext: resourceGitopsModuleDelete,
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsModuleCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsModuleRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsModuleUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsModuleDelete),
+		CustomizeDiff:        resourceGitopsModuleCustomizeDiff,
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsModuleImport,
+		},
+		Timeouts: gitopsResourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"namespace": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"content_dir": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "",
 			},
+			"content_trigger": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "A value included in content_digest, e.g. the sealed_digest of the gitops_seal_secrets resource that writes content_dir, so the module is published again in the same apply when the value changes",
+			},
 			"helm_repo_url": {
 				Type:     schema.TypeString,
 				Optional: true,
@@ -53,16 +61,19 @@ func resourceGitopsModule() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "default",
+				ForceNew: true,
 			},
 			"branch": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "main",
+				ForceNew: true,
 			},
 			"layer": {
 				Type:         schema.TypeString,
 				Required:     true,
 				Description:  "The GitOps layer where the configuration will be deployed (infrastructure, services, applications)",
+				ForceNew:     true,
 				ValidateFunc: validation.StringInSlice([]string{"infrastructure", "services", "applications"}, false),
 			},
 			"type": {
@@ -70,6 +81,7 @@ func resourceGitopsModule() *schema.Resource {
 				Optional:     true,
 				Description:  "The type of component added to the GitOps repo (base, instances, or operators)",
 				Default:      "base",
+				ForceNew:     true,
 				ValidateFunc: validation.StringInSlice([]string{"base", "instances", "operators"}, false),
 			},
 			"value_files": {
@@ -93,16 +105,27 @@ func resourceGitopsModule() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			"content_digest": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Digest of the files in content_dir and the value_files. A change to the files triggers an update of the module",
+			},
+			"applied_digest": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Digest of the ArgoCD application and payload written to the gitops repo by the last apply",
+			},
+			"repo_digest": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Digest of the ArgoCD application and payload found in the gitops repo by the last refresh",
+			},
 		},
-	}
+	})
 }
 
-func resourceGitopsModuleCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-
-	config := m.(*ProviderConfig)
-
-	moduleConfig := GitopsModuleConfig{
+func gitopsModuleConfigFromResourceData(d *schema.ResourceData, config *ProviderConfig) GitopsModuleConfig {
+	return GitopsModuleConfig{
 		Name:        getNameInput(d),
 		Namespace:   getNamespaceInput(d),
 		Branch:      getBranchInput(d),
@@ -118,14 +141,72 @@ func resourceGitopsModuleCreate(ctx context.Context, d *schema.ResourceData, m i
 		HelmConfig:  helmConfigFromResourceData(d),
 		IgnoreDiff:  getIgnoreDiffInput(d),
 	}
+}
 
-	id, err := populateGitopsModule(ctx, config.BinDir, moduleConfig, false)
+func resourceGitopsModuleCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	moduleConfig := gitopsModuleConfigFromResourceData(d, config)
+
+	id, appliedDigest, err := applyGitopsModule(ctx, config, moduleConfig, false)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
 
+	return setGitopsModuleDigests(ctx, d, moduleConfig, appliedDigest)
+}
+
+func resourceGitopsModuleUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	moduleConfig := gitopsModuleConfigFromResourceData(d, config)
+
+	tflog.Info(ctx, fmt.Sprintf("Updating gitops module: name=%s, namespace=%s, serverName=%s", moduleConfig.Name, moduleConfig.Namespace, moduleConfig.ServerName))
+
+	_, appliedDigest, err := applyGitopsModule(ctx, config, moduleConfig, false)
+	if err != nil {
+		return errorDiagnostics(err)
+	}
+
+	return setGitopsModuleDigests(ctx, d, moduleConfig, appliedDigest)
+}
+
+// setGitopsModuleDigests records the digest of the module content and of what was just written to
+// the gitops repo. The digest returned by the native engine is used as is, otherwise the module is
+// read back from the gitops repo. A failure to read it back is only a warning since the module
+// itself was applied, and the digests are cleared so no drift is reported from a stale digest.
+func setGitopsModuleDigests(ctx context.Context, d *schema.ResourceData, moduleConfig GitopsModuleConfig, appliedDigest string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	contentDigest, _, err := gitopsModuleContentDigest(moduleConfig.ContentDir, moduleConfig.ValueFiles, d.Get("content_trigger").(string))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = d.Set("content_digest", contentDigest)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if len(appliedDigest) == 0 {
+		state, err := readGitopsModuleState(ctx, moduleConfig)
+		if err != nil {
+			diags = gitopsModuleReadWarning(err)
+		} else {
+			appliedDigest = state.Digest
+		}
+	}
+
+	err = setResourceValues(d, map[string]interface{}{
+		"applied_digest": appliedDigest,
+		"repo_digest":    appliedDigest,
+	})
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	return diags
 }
 
@@ -133,11 +214,128 @@ func resourceGitopsModuleRead(ctx context.Context, d *schema.ResourceData, m int
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
+	config := m.(*ProviderConfig)
+
+	moduleConfig := gitopsModuleConfigFromResourceData(d, config)
+
+	state, err := readGitopsModuleState(ctx, moduleConfig)
+	if err != nil {
+		return gitopsModuleReadWarning(err)
+	}
+
+	if !state.Exists {
+		tflog.Warn(ctx, fmt.Sprintf("ArgoCD application %s not found in gitops repo. Removing module from state", state.ApplicationFile))
+
+		d.SetId("")
+		return diags
+	}
+
+	appliedDigest := d.Get("applied_digest").(string)
+	if len(appliedDigest) > 0 && appliedDigest != state.Digest {
+		tflog.Warn(ctx, fmt.Sprintf("ArgoCD application %s and/or payload %s changed outside of terraform", state.ApplicationFile, state.PayloadDir))
+	}
+
+	err = d.Set("repo_digest", state.Digest)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	return diags
 }
 
-func resourceGitopsModuleUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	return resourceGitopsModuleRead(ctx, d, m)
+// resourceGitopsModuleCustomizeDiff plans an update when the files in content_dir or value_files
+// or the content_trigger have changed, or when the refreshed contents of the gitops repo no longer
+// match what was applied
+func resourceGitopsModuleCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	if !d.NewValueKnown("content_dir") || !d.NewValueKnown("value_files") || !d.NewValueKnown("content_trigger") {
+		return d.SetNewComputed("content_digest")
+	}
+
+	contentDigest, found, err := gitopsModuleContentDigest(d.Get("content_dir").(string), d.Get("value_files").(string), d.Get("content_trigger").(string))
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		err = d.SetNewComputed("content_digest")
+	} else if contentDigest != d.Get("content_digest").(string) {
+		err = d.SetNew("content_digest", contentDigest)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(d.Id()) == 0 {
+		return nil
+	}
+
+	appliedDigest := d.Get("applied_digest").(string)
+	repoDigest := d.Get("repo_digest").(string)
+
+	if len(appliedDigest) > 0 && appliedDigest != repoDigest {
+		return d.SetNewComputed("repo_digest")
+	}
+
+	return nil
+}
+
+// resourceGitopsModuleImport adopts a module that already exists in the gitops repo. The import id
+// uses the same namespace:name:serverName:layer:type format as the resource id. The helm chart is
+// recovered from the payload but content_dir and value_files only exist locally and must be taken
+// from the configuration.
+func resourceGitopsModuleImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	parts, err := parseImportId(d.Id(), "namespace:name:serverName:layer:type")
+	if err != nil {
+		return nil, err
+	}
+
+	moduleConfig := GitopsModuleConfig{
+		Namespace:  parts[0],
+		Name:       parts[1],
+		ServerName: parts[2],
+		Layer:      parts[3],
+		Type:       parts[4],
+	}
+
+	state, err := importGitopsModule(ctx, d, config, resourceGitopsModule(), moduleConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]interface{}{
+		"namespace":      moduleConfig.Namespace,
+		"name":           moduleConfig.Name,
+		"server_name":    moduleConfig.ServerName,
+		"layer":          moduleConfig.Layer,
+		"type":           moduleConfig.Type,
+		"applied_digest": state.Digest,
+		"repo_digest":    state.Digest,
+	}
+
+	helmConfig := helmConfigFromChart(state.HelmChart, moduleConfig.Name)
+	if helmConfig != nil {
+		values["helm_repo_url"] = helmConfig.RepoUrl
+		values["helm_chart"] = helmConfig.Chart
+		values["helm_chart_version"] = helmConfig.ChartVersion
+	}
+
+	if state.Application != nil && len(state.Application.Spec.IgnoreDifferences) > 0 {
+		ignoreDiff, err := toJson(state.Application.Spec.IgnoreDifferences)
+		if err != nil {
+			return nil, err
+		}
+
+		values["ignore_diff"] = ignoreDiff
+	}
+
+	err = setResourceValues(d, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
 }
 
 func resourceGitopsModuleDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -145,25 +343,11 @@ func resourceGitopsModuleDelete(ctx context.Context, d *schema.ResourceData, m i
 
 	config := m.(*ProviderConfig)
 
-	moduleConfig := GitopsModuleConfig{
-		Name:        getNameInput(d),
-		Namespace:   getNamespaceInput(d),
-		Branch:      getBranchInput(d),
-		ServerName:  getServerNameInput(d),
-		Layer:       getLayerInput(d),
-		Type:        getTypeInput(d),
-		ContentDir:  getContentDirInput(d),
-		ValueFiles:  getValueFilesInput(d),
-		CaCert:      config.GitConfig.CaCertFile,
-		Debug:       config.Debug,
-		Credentials: getCredentialsInput(d),
-		Config:      getGitopsConfigInput(d),
-		HelmConfig:  helmConfigFromResourceData(d),
-	}
+	moduleConfig := gitopsModuleConfigFromResourceData(d, config)
 
-	id, err := populateGitopsModule(ctx, config.BinDir, moduleConfig, true)
+	id, err := populateGitopsModule(ctx, config, moduleConfig, true)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
@@ -171,17 +355,63 @@ func resourceGitopsModuleDelete(ctx context.Context, d *schema.ResourceData, m i
 	return diags
 }
 
-func populateGitopsModule(ctx context.Context, binDir string, gitopsConfig GitopsModuleConfig, delete bool) (string, error) {
+func populateGitopsModule(ctx context.Context, config *ProviderConfig, gitopsConfig GitopsModuleConfig, delete bool) (string, error) {
+	id, _, err := applyGitopsModule(ctx, config, gitopsConfig, delete)
 
-	// this should be replaced with the actual git user
-	username := "cloudnativetoolkit"
+	return id, err
+}
+
+// populateGitopsIgc runs the igc command that writes the module to the gitops repo
+type populateGitopsIgc func(ctx context.Context, executor Executor, gitopsConfig GitopsModuleConfig, delete bool) error
+
+// applyGitopsModule writes the module to the gitops repo. The native engine also returns the digest
+// of what it pushed, the igc engine returns an empty digest.
+func applyGitopsModule(ctx context.Context, config *ProviderConfig, gitopsConfig GitopsModuleConfig, delete bool) (string, string, error) {
+	return applyGitopsModuleWithIgc(ctx, config, gitopsConfig, delete, populateGitopsModuleIgc)
+}
 
-	gitopsMutexKV.Lock(username)
+// applyGitopsModuleWithIgc writes the module to the gitops repo like applyGitopsModule, running
+// populateIgc with the igc engine, e.g. igc gitops-namespace for a namespace
+func applyGitopsModuleWithIgc(ctx context.Context, config *ProviderConfig, gitopsConfig GitopsModuleConfig, delete bool, populateIgc populateGitopsIgc) (string, string, error) {
 
-	defer gitopsMutexKV.Unlock(username)
+	unlock, err := lockGitopsRepos(ctx, gitopsConfig.Config, gitopsConfig.Branch)
+	if err != nil {
+		return "", "", err
+	}
+
+	defer unlock()
 
 	tflog.Info(ctx, fmt.Sprintf("Provisioning gitops module: name=%s, namespace=%s, serverName=%s", gitopsConfig.Name, gitopsConfig.Namespace, gitopsConfig.ServerName))
 
+	var digest string
+	if config.Engine == "native" {
+		err = retryTransient(ctx, config.Retry, "gitops-module "+gitopsConfig.Name, func() error {
+			var nativeErr error
+			digest, nativeErr = populateGitopsModuleNative(ctx, gitopsConfig, delete)
+
+			return nativeErr
+		})
+		if err != nil && ctx.Err() != nil {
+			err = commandContextError(ctx, "gitops-module "+gitopsConfig.Name)
+		}
+	} else {
+		err = populateIgc(ctx, config.Executor, gitopsConfig, delete)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	var id string
+	if delete {
+		id = ""
+	} else {
+		id = gitopsConfig.Namespace + ":" + gitopsConfig.Name + ":" + gitopsConfig.ServerName + ":" + gitopsConfig.Layer + ":" + gitopsConfig.Type
+	}
+
+	return id, digest, nil
+}
+
+func populateGitopsModuleIgc(ctx context.Context, executor Executor, gitopsConfig GitopsModuleConfig, delete bool) error {
 	var args = []string{
 		"gitops-module",
 		gitopsConfig.Name,
@@ -205,7 +435,7 @@ func populateGitopsModule(ctx context.Context, binDir string, gitopsConfig Gitop
 			"--helmChart", helmConfig.Chart,
 			"--helmChartVersion", helmConfig.ChartVersion)
 	} else {
-		return "", errors.New("contentDir or helmRepoUrl, helmChart, and helmChartVersion are required")
+		return errors.New("contentDir or helmRepoUrl, helmChart, and helmChartVersion are required")
 	}
 
 	if len(gitopsConfig.ValueFiles) > 0 {
@@ -221,72 +451,10 @@ func populateGitopsModule(ctx context.Context, binDir string, gitopsConfig Gitop
 		args = append(args, "--ignoreDiff", gitopsConfig.IgnoreDiff)
 	}
 
-	cmd := exec.Command(filepath.Join(binDir, "igc"), args...)
-
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
-
-	updatedEnv := append(os.Environ(), "GIT_CREDENTIALS="+gitopsConfig.Credentials)
-	updatedEnv = append(updatedEnv, "GITOPS_CONFIG="+gitopsConfig.Config)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
-
-	logEnvironment(ctx, &updatedEnv)
-
-	cmd.Env = updatedEnv
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return "", err
-	}
-
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
-	}
-
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		return "", err
-	}
-
-	// read command's stdout line by line
-	in := bufio.NewScanner(stdout)
-	inErr := bufio.NewScanner(stderr)
-
-	for in.Scan() {
-		if gitopsConfig.Debug == "true" {
-			tflog.Debug(ctx, in.Text())
-		} else {
-			tflog.Info(ctx, in.Text())
-		}
-	}
-
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		return "", err
-	}
-
-	if err := in.Err(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error processing stream: %s", fmt.Sprintln(err)))
-		return "", err
-	}
-
-	var id string
-	if delete {
-		id = ""
-	} else {
-		id = gitopsConfig.Namespace + ":" + gitopsConfig.Name + ":" + gitopsConfig.ServerName + ":" + gitopsConfig.Layer + ":" + gitopsConfig.Type
-	}
-
-	return id, nil
+	return executor.Execute(ctx, CommandRequest{
+		Name:  "igc",
+		Args:  args,
+		Env:   gitopsEnv(gitopsConfig.Credentials, gitopsConfig.Config),
+		Debug: gitopsConfig.Debug == "true",
+	})
 }
@@ -0,0 +1,114 @@
+package gitops
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testGitopsConfig points every layer at a repo that does not exist, so reading the module back
+// from the gitops repo fails fast without network access
+func testGitopsConfig(t *testing.T) string {
+	url := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing-repo"))
+
+	return `{
+  "infrastructure": {
+    "argocd-config": {"url": "` + url + `", "path": "argocd/1-infrastructure"},
+    "payload": {"url": "` + url + `", "path": "payload/1-infrastructure"}
+  }
+}`
+}
+
+const testGitopsCredentials = `[{"repo": "gitops", "url": "https://github.com/org/gitops", "username": "admin", "token": "gitops-token"}]`
+
+// testProviderConfig returns the provider config of a resource test with the executor replaced
+func testProviderConfig(executor Executor) *ProviderConfig {
+	return &ProviderConfig{
+		GitConfig: &GitConfigValues{CaCertFile: "/certs/ca.crt"},
+		Engine:    "igc",
+		Debug:     "false",
+		Executor:  executor,
+	}
+}
+
+// assertNoErrors fails the test on error diagnostics. Warnings, e.g. because the module cannot be
+// read back from the gitops repo, are allowed.
+func assertNoErrors(t *testing.T, diags diag.Diagnostics) {
+	t.Helper()
+
+	for _, d := range diags {
+		if d.Severity == diag.Error {
+			t.Fatalf("unexpected error: %s: %s", d.Summary, d.Detail)
+		}
+	}
+}
+
+// assertEnv checks that the environment of the request holds the expected variable
+func assertEnv(t *testing.T, request CommandRequest, name string, expected string) {
+	t.Helper()
+
+	for _, value := range request.Env {
+		if key, actual, found := strings.Cut(value, "="); found && key == name {
+			if actual != expected {
+				t.Errorf("expected %s=%q, got %q", name, expected, actual)
+			}
+			return
+		}
+	}
+
+	t.Errorf("expected %s in the environment of %s, got %v", name, request.command(), request.Env)
+}
+
+func TestResourceGitopsModuleCreateRunsIgc(t *testing.T) {
+	executor := &RecordingExecutor{}
+	config := testGitopsConfig(t)
+
+	d := schema.TestResourceDataRaw(t, resourceGitopsModule().Schema, map[string]interface{}{
+		"name":        "my-module",
+		"namespace":   "my-namespace",
+		"layer":       "infrastructure",
+		"content_dir": "content",
+		"credentials": testGitopsCredentials,
+		"config":      config,
+	})
+
+	diags := resourceGitopsModuleCreate(context.Background(), d, testProviderConfig(executor))
+	assertNoErrors(t, diags)
+
+	if d.Id() != "my-namespace:my-module:default:infrastructure:base" {
+		t.Errorf("unexpected id %q", d.Id())
+	}
+
+	requests := executor.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(requests))
+	}
+
+	request := requests[0]
+	if request.Name != "igc" {
+		t.Errorf("expected igc, got %s", request.Name)
+	}
+
+	expectedArgs := []string{
+		"gitops-module", "my-module",
+		"-n", "my-namespace",
+		"--branch", "main",
+		"--serverName", "default",
+		"--layer", "infrastructure",
+		"--type", "base",
+		"--contentDir", "content",
+		"--caCert", "/certs/ca.crt",
+		"--debug", "false",
+	}
+	if !reflect.DeepEqual(request.Args, expectedArgs) {
+		t.Errorf("unexpected args\nexpected: %v\nactual:   %v", expectedArgs, request.Args)
+	}
+
+	assertEnv(t, request, "GIT_CREDENTIALS", testGitopsCredentials)
+	assertEnv(t, request, "GITOPS_CONFIG", config)
+	assertEnv(t, request, "GIT_AUTHOR_NAME", gitName)
+}
@@ -1,29 +1,31 @@
 package gitops
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"gopkg.in/yaml.v3"
-	"log"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strings"
 )
 
 func resourceGitopsNamespace() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsNamespaceCreate,
-		ReadContext:   resourceGitopsNamespaceRead,
-		UpdateContext: resourceGitopsNamespaceUpdate,
-		DeleteContext: resourceGitopsNamespaceDelete,
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsNamespaceCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsNamespaceRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsNamespaceUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsNamespaceDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsNamespaceImport,
+		},
+		Timeouts: gitopsResourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"content_dir": &schema.Schema{
 				Type:     schema.TypeString,
@@ -34,6 +36,7 @@ func resourceGitopsNamespace() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "default",
+				ForceNew: true,
 			},
 			"create_operator_group": &schema.Schema{
 				Type:     schema.TypeBool,
@@ -59,6 +62,7 @@ func resourceGitopsNamespace() *schema.Resource {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "main",
+				ForceNew: true,
 			},
 			"value_files": &schema.Schema{
 				Type:     schema.TypeString,
@@ -75,7 +79,7 @@ func resourceGitopsNamespace() *schema.Resource {
 				Required: true,
 			},
 		},
-	}
+	})
 }
 
 type GitopsConfigValues struct {
@@ -97,17 +101,14 @@ func resourceGitopsNamespaceCreate(ctx context.Context, d *schema.ResourceData,
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
-	fmt.Printf("Creating gitops namespace")
+	tflog.Info(ctx, "Creating gitops namespace")
 
 	config := m.(*ProviderConfig)
 
 	name := d.Get("name").(string)
 	contentDir := d.Get("content_dir").(string)
 	serverName := d.Get("server_name").(string)
-	branch := d.Get("branch").(string)
 	valueFiles := d.Get("value_files").(string)
-	credentials := d.Get("credentials").(string)
-	gitopsConfig := d.Get("config").(string)
 
 	createOperatorGroup := d.Get("create_operator_group").(bool)
 	argocdNamespace := d.Get("argocd_namespace").(string)
@@ -130,18 +131,6 @@ func resourceGitopsNamespaceCreate(ctx context.Context, d *schema.ResourceData,
 	valuesPath := fmt.Sprintf("%s/namespace/%s", tmpDir, name)
 	valuesFile := fmt.Sprintf("%s/values.yaml", valuesPath)
 
-	binDir := config.BinDir
-	lock := config.Lock
-	debug := config.Debug
-	caCert := config.GitConfig.CaCertFile
-
-	// this should be replaced with the actual git user
-	username := "cloudnativetoolkit"
-
-	gitopsMutexKV.Lock(username)
-
-	defer gitopsMutexKV.Unlock(username)
-
 	tflog.Info(ctx, fmt.Sprintf("Provisioning gitops namespace: name=%s, serverName=%s", name, serverName))
 
 	err = os.MkdirAll(valuesPath, os.ModePerm)
@@ -153,113 +142,159 @@ func resourceGitopsNamespaceCreate(ctx context.Context, d *schema.ResourceData,
 		return diag.FromErr(err)
 	}
 
-	var args = []string{
-		"gitops-namespace",
-		name,
-		"--branch", branch,
-		"--serverName", serverName}
-
+	namespaceConfig := namespaceModuleConfig(d, config)
 	if len(contentDir) > 0 {
-		args = append(args, "--contentDir", contentDir)
-
-		if len(valueFiles) > 0 {
-			args = append(args, "--valueFiles", valueFiles)
-		}
+		namespaceConfig.ContentDir = contentDir
+		namespaceConfig.ValueFiles = valueFiles
 	} else {
-		args = append(args,
-			"--helmRepoUrl", "https://charts.cloudnativetoolkit.dev",
-			"--helmChart", "namespace",
-			"--helmChartVersion", "0.2.0",
-			"--valueFiles", valuesFile)
+		namespaceConfig.HelmConfig = &HelmConfig{
+			RepoUrl:      "https://charts.cloudnativetoolkit.dev",
+			Chart:        "namespace",
+			ChartVersion: "0.2.0",
+		}
+		namespaceConfig.ValueFiles = valuesFile
 	}
 
-	if len(lock) > 0 {
-		args = append(args, "--lock", lock)
-	}
-	if len(caCert) > 0 {
-		args = append(args, "--caCert", caCert)
-	}
-	if len(debug) > 0 {
-		args = append(args, "--debug", debug)
+	_, _, err = applyGitopsModuleWithIgc(ctx, config, namespaceConfig, false, populateGitopsNamespaceIgc(config.Lock))
+	if err != nil {
+		return errorDiagnostics(err)
 	}
 
-	cmd := exec.Command(filepath.Join(binDir, "igc"), args...)
+	d.SetId(name + ":" + serverName + ":" + contentDir)
 
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
+	return diags
+}
 
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
+// populateGitopsNamespaceIgc returns the function that runs igc gitops-namespace for the namespace
+// described by the module config
+func populateGitopsNamespaceIgc(lock string) populateGitopsIgc {
+	return func(ctx context.Context, executor Executor, gitopsConfig GitopsModuleConfig, delete bool) error {
+		var args = []string{
+			"gitops-namespace",
+			gitopsConfig.Namespace}
+
+		if delete {
+			args = append(args,
+				"--delete",
+				"--contentDir", gitopsConfig.ContentDir,
+				"--branch", gitopsConfig.Branch,
+				"--serverName", gitopsConfig.ServerName)
+
+			if len(lock) > 0 {
+				args = append(args, "--lock", lock)
+			}
+			if len(gitopsConfig.ValueFiles) > 0 {
+				args = append(args, "--valueFiles", gitopsConfig.ValueFiles)
+			}
+		} else {
+			args = append(args,
+				"--branch", gitopsConfig.Branch,
+				"--serverName", gitopsConfig.ServerName)
+
+			if len(gitopsConfig.ContentDir) > 0 {
+				args = append(args, "--contentDir", gitopsConfig.ContentDir)
+
+				if len(gitopsConfig.ValueFiles) > 0 {
+					args = append(args, "--valueFiles", gitopsConfig.ValueFiles)
+				}
+			} else if gitopsConfig.HelmConfig != nil {
+				args = append(args,
+					"--helmRepoUrl", gitopsConfig.HelmConfig.RepoUrl,
+					"--helmChart", gitopsConfig.HelmConfig.Chart,
+					"--helmChartVersion", gitopsConfig.HelmConfig.ChartVersion,
+					"--valueFiles", gitopsConfig.ValueFiles)
+			}
+
+			if len(lock) > 0 {
+				args = append(args, "--lock", lock)
+			}
+		}
 
-	updatedEnv := append(os.Environ(), "GIT_CREDENTIALS="+credentials)
-	updatedEnv = append(updatedEnv, "GITOPS_CONFIG="+gitopsConfig)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
+		if len(gitopsConfig.CaCert) > 0 {
+			args = append(args, "--caCert", gitopsConfig.CaCert)
+		}
+		if len(gitopsConfig.Debug) > 0 {
+			args = append(args, "--debug", gitopsConfig.Debug)
+		}
 
-	logEnvironment(ctx, &updatedEnv)
+		return executor.Execute(ctx, CommandRequest{
+			Name:  "igc",
+			Args:  args,
+			Env:   gitopsEnv(gitopsConfig.Credentials, gitopsConfig.Config),
+			Debug: gitopsConfig.Debug == "true",
+		})
+	}
+}
 
-	cmd.Env = updatedEnv
+func resourceGitopsNamespaceRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	tflog.Info(ctx, "Reading gitops-namespace")
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return diag.FromErr(err)
-	}
+	config := m.(*ProviderConfig)
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return diag.FromErr(err)
-	}
+	return readGitopsModuleExists(ctx, d, namespaceModuleConfig(d, config))
+}
 
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		return diag.FromErr(err)
+// namespaceModuleConfig describes where the namespace is stored in the gitops repo
+func namespaceModuleConfig(d *schema.ResourceData, config *ProviderConfig) GitopsModuleConfig {
+	return GitopsModuleConfig{
+		Name:        "namespace",
+		Namespace:   d.Get("name").(string),
+		Branch:      d.Get("branch").(string),
+		ServerName:  d.Get("server_name").(string),
+		Layer:       "infrastructure",
+		Type:        "base",
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
+		Credentials: d.Get("credentials").(string),
+		Config:      d.Get("config").(string),
 	}
+}
 
-	// read command's stdout line by line
-	in := bufio.NewScanner(stdout)
-	inErr := bufio.NewScanner(stderr)
+// resourceGitopsNamespaceImport adopts a namespace that already exists in the gitops repo. The import
+// id is the resource id, name:serverName with an optional :contentDir suffix.
+func resourceGitopsNamespaceImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
 
-	for in.Scan() {
-		if debug == "true" {
-			tflog.Debug(ctx, in.Text())
-		} else {
-			tflog.Info(ctx, in.Text())
-		}
+	parts := strings.SplitN(d.Id(), ":", 3)
+	if len(parts) < 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("unexpected format of import id (%s), expected name:serverName[:contentDir]", d.Id())
 	}
 
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
+	contentDir := ""
+	if len(parts) == 3 {
+		contentDir = parts[2]
 	}
 
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		return diag.FromErr(err)
+	moduleConfig := GitopsModuleConfig{
+		Name:       "namespace",
+		Namespace:  parts[0],
+		ServerName: parts[1],
+		Layer:      "infrastructure",
+		Type:       "base",
 	}
 
-	if err := in.Err(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error processing stream: %s", fmt.Sprintln(err)))
-		return diag.FromErr(err)
+	_, err := importGitopsModule(ctx, d, config, resourceGitopsNamespace(), moduleConfig)
+	if err != nil {
+		return nil, err
 	}
 
-	d.SetId(name + ":" + serverName + ":" + contentDir)
-
-	return diags
-}
-
-func resourceGitopsNamespaceRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	// Warning or errors can be collected in a slice type
-	var diags diag.Diagnostics
+	err = setResourceValues(d, map[string]interface{}{
+		"name":        moduleConfig.Namespace,
+		"server_name": moduleConfig.ServerName,
+		"content_dir": contentDir,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	log.Printf("Reading gitops-namespace")
+	d.SetId(moduleConfig.Namespace + ":" + moduleConfig.ServerName + ":" + contentDir)
 
-	return diags
+	return []*schema.ResourceData{d}, nil
 }
 
 func resourceGitopsNamespaceUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 
-	log.Printf("Updating gitops-namespace")
+	tflog.Info(ctx, "Updating gitops-namespace")
 
 	return resourceGitopsNamespaceRead(ctx, d, m)
 }
@@ -270,106 +305,15 @@ func resourceGitopsNamespaceDelete(ctx context.Context, d *schema.ResourceData,
 
 	config := m.(*ProviderConfig)
 
-	binDir := config.BinDir
-	lock := config.Lock
-	debug := config.Debug
-	caCert := config.GitConfig.CaCertFile
-
-	username := "cloudnativetoolkit"
-
-	name := d.Get("name").(string)
-	serverName := d.Get("server_name").(string)
-	contentDir := d.Get("content_dir").(string)
-	branch := d.Get("branch").(string)
-	valueFiles := d.Get("value_files").(string)
-	credentials := d.Get("credentials").(string)
-	gitopsConfig := d.Get("config").(string)
-
-	gitopsMutexKV.Lock(username)
-
-	defer gitopsMutexKV.Unlock(username)
-
-	tflog.Info(ctx, fmt.Sprintf("Destroying gitops namespace: name=%s, serverName=%s", name, serverName))
-
-	var args = []string{
-		"gitops-namespace",
-		name,
-		"--delete",
-		"--contentDir", contentDir,
-		"--branch", branch,
-		"--serverName", serverName}
-
-	if len(lock) > 0 {
-		args = append(args, "--lock", lock)
-	}
-	if len(valueFiles) > 0 {
-		args = append(args, "--valueFiles", valueFiles)
-	}
-	if len(caCert) > 0 {
-		args = append(args, "--caCert", caCert)
-	}
-	if len(debug) > 0 {
-		args = append(args, "--debug", debug)
-	}
-
-	cmd := exec.Command(filepath.Join(binDir, "igc"), args...)
-
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	gitEmail := "cloudnativetoolkit@gmail.com"
-	gitName := "Cloud Native Toolkit"
-
-	updatedEnv := append(os.Environ(), "GIT_CREDENTIALS="+credentials)
-	updatedEnv = append(updatedEnv, "GITOPS_CONFIG="+gitopsConfig)
-	updatedEnv = append(updatedEnv, "EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_AUTHOR_NAME="+gitName)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_EMAIL="+gitEmail)
-	updatedEnv = append(updatedEnv, "GIT_COMMITTER_NAME="+gitName)
+	namespaceConfig := namespaceModuleConfig(d, config)
+	namespaceConfig.ContentDir = d.Get("content_dir").(string)
+	namespaceConfig.ValueFiles = d.Get("value_files").(string)
 
-	logEnvironment(ctx, &updatedEnv)
+	tflog.Info(ctx, fmt.Sprintf("Destroying gitops namespace: name=%s, serverName=%s", namespaceConfig.Namespace, namespaceConfig.ServerName))
 
-	cmd.Env = updatedEnv
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return diag.FromErr(err)
-	}
-
-	stderr, err := cmd.StderrPipe()
+	_, _, err := applyGitopsModuleWithIgc(ctx, config, namespaceConfig, true, populateGitopsNamespaceIgc(config.Lock))
 	if err != nil {
-		return diag.FromErr(err)
-	}
-
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		return diag.FromErr(err)
-	}
-
-	// read command's stdout line by line
-	in := bufio.NewScanner(stdout)
-	inErr := bufio.NewScanner(stderr)
-
-	for in.Scan() {
-		if debug == "true" {
-			tflog.Debug(ctx, in.Text())
-		} else {
-			tflog.Info(ctx, in.Text())
-		}
-	}
-
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		return diag.FromErr(err)
-	}
-
-	if err := in.Err(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error processing stream: %s", fmt.Sprintln(err)))
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId("")
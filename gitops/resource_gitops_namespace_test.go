@@ -0,0 +1,96 @@
+package gitops
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResourceGitopsNamespaceRunsIgc(t *testing.T) {
+	executor := &RecordingExecutor{}
+	tmpDir := t.TempDir()
+
+	d := schema.TestResourceDataRaw(t, resourceGitopsNamespace().Schema, map[string]interface{}{
+		"name":        "my-namespace",
+		"credentials": testGitopsCredentials,
+		"config":      testGitopsConfig(t),
+		"tmp_dir":     tmpDir,
+	})
+
+	providerConfig := testProviderConfig(executor)
+	providerConfig.Lock = "branch"
+
+	diags := resourceGitopsNamespaceCreate(context.Background(), d, providerConfig)
+	assertNoErrors(t, diags)
+
+	if d.Id() != "my-namespace:default:" {
+		t.Errorf("unexpected id %q", d.Id())
+	}
+
+	diags = resourceGitopsNamespaceDelete(context.Background(), d, providerConfig)
+	assertNoErrors(t, diags)
+
+	requests := executor.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 commands, got %d", len(requests))
+	}
+
+	expectedCreate := []string{
+		"gitops-namespace", "my-namespace",
+		"--branch", "main",
+		"--serverName", "default",
+		"--helmRepoUrl", "https://charts.cloudnativetoolkit.dev",
+		"--helmChart", "namespace",
+		"--helmChartVersion", "0.2.0",
+		"--valueFiles", filepath.Join(tmpDir, "namespace", "my-namespace", "values.yaml"),
+		"--lock", "branch",
+		"--caCert", "/certs/ca.crt",
+		"--debug", "false",
+	}
+	if !reflect.DeepEqual(requests[0].Args, expectedCreate) {
+		t.Errorf("unexpected create args\nexpected: %v\nactual:   %v", expectedCreate, requests[0].Args)
+	}
+
+	expectedDelete := []string{
+		"gitops-namespace", "my-namespace",
+		"--delete",
+		"--contentDir", "",
+		"--branch", "main",
+		"--serverName", "default",
+		"--lock", "branch",
+		"--caCert", "/certs/ca.crt",
+		"--debug", "false",
+	}
+	if !reflect.DeepEqual(requests[1].Args, expectedDelete) {
+		t.Errorf("unexpected delete args\nexpected: %v\nactual:   %v", expectedDelete, requests[1].Args)
+	}
+
+	assertEnv(t, requests[0], "GIT_CREDENTIALS", testGitopsCredentials)
+}
+
+func TestResourceGitopsNamespaceNativeReportsCancellation(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceGitopsNamespace().Schema, map[string]interface{}{
+		"name":        "my-namespace",
+		"credentials": testGitopsCredentials,
+		"config":      testGitopsConfig(t),
+		"tmp_dir":     t.TempDir(),
+	})
+
+	providerConfig := testProviderConfig(&RecordingExecutor{})
+	providerConfig.Engine = "native"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	diags := resourceGitopsNamespaceCreate(ctx, d, providerConfig)
+	if !diags.HasError() {
+		t.Fatal("expected an error")
+	}
+
+	if !strings.Contains(diags[0].Summary+diags[0].Detail, "was cancelled") {
+		t.Errorf("expected the cancellation to be reported, got %s: %s", diags[0].Summary, diags[0].Detail)
+	}
+}
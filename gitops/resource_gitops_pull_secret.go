@@ -1,53 +1,63 @@
 package gitops
 
 import (
-	"bufio"
 	"context"
+	b64 "encoding/base64"
+	"encoding/json"
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"os"
-	"os/exec"
 	"path"
-	"path/filepath"
 )
 
 func resourceGitopsPullSecret() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsPullSecretCreate,
-		ReadContext:   resourceGitopsPullSecretRead,
-		UpdateContext: resourceGitopsPullSecretUpdate,
-		DeleteContext: resourceGitopsPullSecretDelete,
-		Schema: map[string]*schema.Schema{
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsPullSecretCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsPullSecretRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsPullSecretUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsPullSecretDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsPullSecretImport,
+		},
+		CustomizeDiff: resourceGitopsPullSecretCustomizeDiff,
+		Timeouts:      gitopsResourceTimeouts(),
+		Schema: withSealingCertSchema(map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"namespace": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"server_name": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "default",
+				ForceNew: true,
 			},
 			"branch": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "main",
+				ForceNew: true,
 			},
 			"layer": {
 				Type:         schema.TypeString,
 				Required:     true,
+				ForceNew:     true,
 				ValidateFunc: validation.StringInSlice([]string{"infrastructure", "services", "applications"}, false),
 			},
 			"type": {
 				Type:         schema.TypeString,
 				Optional:     true,
 				Default:      "base",
+				ForceNew:     true,
 				ValidateFunc: validation.StringInSlice([]string{"base", "instances", "operators"}, false),
 			},
 			"credentials": {
@@ -60,25 +70,80 @@ func resourceGitopsPullSecret() *schema.Resource {
 				Required: true,
 			},
 			"kubeseal_cert": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The PEM encoded certificate of the sealed-secrets controller used to seal the secret",
+				ValidateFunc: validateSealingCert,
+			},
+			"scope": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      sealedSecretScopeStrict,
+				Description:  "The scope of the sealed pull secret: strict, namespace-wide or cluster-wide. A strict secret can only be unsealed with the same name and namespace, a namespace-wide secret can be renamed and a cluster-wide secret can be moved to any namespace.",
+				ValidateFunc: validation.StringInSlice(sealedSecretScopes, false),
+			},
+			"rotation_trigger": {
 				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The certificate that will be used to encrypt the secret with kubeseal",
+				Optional:    true,
+				Description: "An arbitrary value that re-seals the pull secret and pushes it to the gitops repo when it changes, e.g. after the sealed-secrets controller rotated its key.",
 			},
 			"registry_server": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The host name of the server that will be stored in the pull secret",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The host name of the server that will be stored in the pull secret",
+				AtLeastOneOf: []string{"registry_server", "registry"},
+				RequiredWith: []string{"registry_username", "registry_password"},
 			},
 			"registry_username": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Description: "The username to the container registry that will be stored in the pull secret",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The username to the container registry that will be stored in the pull secret",
+				RequiredWith: []string{"registry_server"},
 			},
 			"registry_password": {
-				Type:        schema.TypeString,
-				Required:    true,
-				Sensitive:   true,
-				Description: "The password to the container registry that will be stored in the pull secret",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Sensitive:    true,
+				Description:  "The password to the container registry that will be stored in the pull secret",
+				RequiredWith: []string{"registry_server"},
+			},
+			"registry": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The container registries stored in the pull secret. The entries, and the registry_server if provided, are merged into a single .dockerconfigjson.",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"server": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The host name of the container registry",
+						},
+						"username": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "The username to the container registry",
+						},
+						"password": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Sensitive:   true,
+							Description: "The password to the container registry",
+						},
+						"email": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "",
+							Description: "The email address of the user",
+						},
+						"auth": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Default:     "",
+							Description: "The base64 encoded auth value of the registry. If not provided it is computed from the username and password.",
+						},
+					},
+				},
 			},
 			"secret_name": {
 				Type:        schema.TypeString,
@@ -91,223 +156,253 @@ func resourceGitopsPullSecret() *schema.Resource {
 				Optional: true,
 				Default:  "",
 			},
-		},
-	}
+		}, "kubeseal_cert"),
+	})
 }
 
-type PullSecretConfig struct {
-	Name      string
-	Namespace string
-	Server    string
-	Username  string
-	Password  string
+type RegistryCredentials struct {
+	Server   string
+	Username string
+	Password string
+	Email    string
+	Auth     string
 }
 
-func resourceGitopsPullSecretCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
+type PullSecretConfig struct {
+	Name       string
+	Namespace  string
+	Registries []RegistryCredentials
+}
 
-	config := m.(*ProviderConfig)
+// getPullSecretConfig collects the registry_server and the registry blocks of the resource
+func getPullSecretConfig(d *schema.ResourceData) PullSecretConfig {
+	pullSecretName := d.Get("secret_name").(string)
+	if len(pullSecretName) == 0 {
+		pullSecretName = getNameInput(d)
+	}
 
-	namespace := getNamespaceInput(d)
-	name := getNameInput(d)
+	registries := []RegistryCredentials{}
 
-	cert := d.Get("kubeseal_cert").(string)
-	secretName := d.Get("secret_name").(string)
-	tmpDir := d.Get("tmp_dir").(string)
-	if len(tmpDir) == 0 {
-		tmpDir = fmt.Sprintf(".tmp/pull_secret/%s/%s", namespace, name)
+	if server := d.Get("registry_server").(string); len(server) > 0 {
+		registries = append(registries, RegistryCredentials{
+			Server:   server,
+			Username: d.Get("registry_username").(string),
+			Password: d.Get("registry_password").(string),
+		})
 	}
 
-	binDir := config.BinDir
-
-	secretDir := path.Join(tmpDir, name, "secrets")
-	contentDir := path.Join(tmpDir, name, "sealed-secrets")
+	for _, item := range d.Get("registry").([]interface{}) {
+		registry := item.(map[string]interface{})
 
-	var pullSecretName string
-	if len(secretName) > 0 {
-		pullSecretName = secretName
-	} else {
-		pullSecretName = name
+		registries = append(registries, RegistryCredentials{
+			Server:   registry["server"].(string),
+			Username: registry["username"].(string),
+			Password: registry["password"].(string),
+			Email:    registry["email"].(string),
+			Auth:     registry["auth"].(string),
+		})
 	}
 
-	pullSecretConfig := PullSecretConfig{
-		Name:      pullSecretName,
-		Namespace: namespace,
-		Server:    d.Get("registry_server").(string),
-		Username:  d.Get("registry_username").(string),
-		Password:  d.Get("registry_password").(string),
+	return PullSecretConfig{
+		Name:       pullSecretName,
+		Namespace:  getNamespaceInput(d),
+		Registries: registries,
 	}
+}
+
+func resourceGitopsPullSecretCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	workDir := sealedSecretWorkDir(d, "pull_secret")
 
-	// create secret in secretDir
-	secretFile, err := createSecret(ctx, binDir, secretDir, "pull-secret.yaml", pullSecretConfig)
+	// earlier versions wrote the unsealed pull secret to the secrets dir and left it behind
+	err := secureRemoveAll(path.Join(workDir, "secrets"))
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
-	_, err = encryptWithCert(ctx, binDir, tmpDir, secretDir, contentDir, secretFile, cert)
+	err = sealPullSecret(ctx, sealedSecretContentDir(workDir), "pull-secret.yaml", getPullSecretConfig(d), d.Get("kubeseal_cert").(string), d.Get("scope").(string))
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
+	}
+
+	return publishSealedSecretModule(ctx, d, config, workDir)
+}
+
+func resourceGitopsPullSecretRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	return readGitopsModuleExists(ctx, d, sealedSecretModuleConfig(d, config, ""))
+}
+
+// resourceGitopsPullSecretImport adopts a pull secret that already exists in the gitops repo using
+// the namespace:name:serverName:layer:type import id. The registry credentials and kubeseal_cert are
+// not recoverable from the sealed secret and must be taken from the configuration.
+func resourceGitopsPullSecretImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	parts, err := parseImportId(d.Id(), "namespace:name:serverName:layer:type")
+	if err != nil {
+		return nil, err
 	}
 
 	moduleConfig := GitopsModuleConfig{
-		Name:        name,
-		Namespace:   namespace,
-		Branch:      getBranchInput(d),
-		ServerName:  getServerNameInput(d),
-		Layer:       getLayerInput(d),
-		Type:        getTypeInput(d),
-		ContentDir:  contentDir,
-		CaCert:      config.GitConfig.CaCertFile,
-		Debug:       config.Debug,
-		Credentials: getCredentialsInput(d),
-		Config:      getGitopsConfigInput(d),
+		Namespace:  parts[0],
+		Name:       parts[1],
+		ServerName: parts[2],
+		Layer:      parts[3],
+		Type:       parts[4],
 	}
 
-	id, err := populateGitopsModule(ctx, binDir, moduleConfig, false)
+	_, err = importGitopsModule(ctx, d, config, resourceGitopsPullSecret(), moduleConfig)
 	if err != nil {
-		return diag.FromErr(err)
+		return nil, err
 	}
 
-	d.SetId(id)
+	err = setResourceValues(d, map[string]interface{}{
+		"namespace":   moduleConfig.Namespace,
+		"name":        moduleConfig.Name,
+		"server_name": moduleConfig.ServerName,
+		"layer":       moduleConfig.Layer,
+		"type":        moduleConfig.Type,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	return diags
+	return []*schema.ResourceData{d}, nil
 }
 
-func resourceGitopsPullSecretRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	// Warning or errors can be collected in a slice type
-	var diags diag.Diagnostics
-
-	return diags
+// resourceGitopsPullSecretCustomizeDiff plans the fingerprint and expiry of the kubeseal cert
+func resourceGitopsPullSecretCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	return customizeSealingCertDiff(d, "kubeseal_cert")
 }
 
 func resourceGitopsPullSecretUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// a new cert, scope or registry credentials, or a new rotation trigger, re-seal the pull secret
+	// and push it through the module flow again
+	if d.HasChanges("kubeseal_cert", "rotation_trigger", "scope", "secret_name", "registry_server", "registry_username", "registry_password", "registry") {
+		return resourceGitopsPullSecretCreate(ctx, d, m)
+	}
+
 	return resourceGitopsPullSecretRead(ctx, d, m)
 }
 
+// resourceGitopsPullSecretDelete removes the module from the gitops repo using the identity in the
+// state. The pull secret is not generated or sealed again, so neither the registry credentials nor a
+// valid kubeseal_cert are needed, and the tmp dirs left by create are removed.
 func resourceGitopsPullSecretDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-
 	config := m.(*ProviderConfig)
 
-	name := getNameInput(d)
-	namespace := getNamespaceInput(d)
+	return deleteSealedSecretModule(ctx, d, config, sealedSecretWorkDir(d, "pull_secret"))
+}
 
-	cert := d.Get("kubeseal_cert").(string)
-	secretName := d.Get("secret_name").(string)
-	tmpDir := d.Get("tmp_dir").(string)
-	if len(tmpDir) == 0 {
-		tmpDir = fmt.Sprintf(".tmp/pull_secret/%s/%s", namespace, name)
-	}
+type SecretMetadata struct {
+	Name        string            `json:"name" yaml:"name"`
+	Namespace   string            `json:"namespace" yaml:"namespace"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
 
-	binDir := config.BinDir
+type KubernetesSecret struct {
+	ApiVersion string            `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string            `json:"kind" yaml:"kind"`
+	Metadata   SecretMetadata    `json:"metadata" yaml:"metadata"`
+	Type       string            `json:"type" yaml:"type"`
+	Data       map[string]string `json:"data" yaml:"data"`
+	StringData map[string]string `json:"stringData,omitempty" yaml:"stringData,omitempty"`
+}
 
-	secretDir := path.Join(tmpDir, name, "secrets")
-	contentDir := path.Join(tmpDir, name, "sealed-secrets")
+type DockerConfigAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	Auth     string `json:"auth"`
+}
 
-	var pullSecretName string
-	if len(secretName) > 0 {
-		pullSecretName = secretName
-	} else {
-		pullSecretName = name
-	}
+type DockerConfigJson struct {
+	Auths map[string]DockerConfigAuth `json:"auths"`
+}
 
-	pullSecretConfig := PullSecretConfig{
-		Name:      pullSecretName,
-		Namespace: namespace,
-		Server:    d.Get("registry_server").(string),
-		Username:  d.Get("registry_username").(string),
-		Password:  d.Get("registry_password").(string),
+// dockerConfigJsonSecret merges the credentials of the registries into the .dockerconfigjson of a
+// single pull secret
+func dockerConfigJsonSecret(secretData PullSecretConfig) (*KubernetesSecret, error) {
+	dockerConfig := DockerConfigJson{
+		Auths: map[string]DockerConfigAuth{},
 	}
 
-	// create secret in secretDir
-	secretFile, err := createSecret(ctx, binDir, secretDir, "pull-secret.yaml", pullSecretConfig)
-	if err != nil {
-		return diag.FromErr(err)
-	}
+	for _, registry := range secretData.Registries {
+		if _, found := dockerConfig.Auths[registry.Server]; found {
+			return nil, fmt.Errorf("registry %s is defined more than once in the pull secret", registry.Server)
+		}
 
-	_, err = encryptWithCert(ctx, binDir, tmpDir, secretDir, contentDir, secretFile, cert)
-	if err != nil {
-		return diag.FromErr(err)
-	}
+		auth := registry.Auth
+		if len(auth) == 0 {
+			auth = b64.StdEncoding.EncodeToString([]byte(registry.Username + ":" + registry.Password))
+		}
 
-	moduleConfig := GitopsModuleConfig{
-		Name:        name,
-		Namespace:   namespace,
-		Branch:      getBranchInput(d),
-		ServerName:  getServerNameInput(d),
-		Layer:       getLayerInput(d),
-		Type:        getTypeInput(d),
-		ContentDir:  contentDir,
-		CaCert:      config.GitConfig.CaCertFile,
-		Debug:       config.Debug,
-		Credentials: getCredentialsInput(d),
-		Config:      getGitopsConfigInput(d),
+		dockerConfig.Auths[registry.Server] = DockerConfigAuth{
+			Username: registry.Username,
+			Password: registry.Password,
+			Email:    registry.Email,
+			Auth:     auth,
+		}
 	}
 
-	id, err := populateGitopsModule(ctx, binDir, moduleConfig, true)
+	dockerConfigData, err := json.Marshal(dockerConfig)
 	if err != nil {
-		return diag.FromErr(err)
+		return nil, err
 	}
 
-	d.SetId(id)
-
-	return diags
+	return &KubernetesSecret{
+		ApiVersion: "v1",
+		Kind:       "Secret",
+		Metadata: SecretMetadata{
+			Name:      secretData.Name,
+			Namespace: secretData.Namespace,
+		},
+		Type: "kubernetes.io/dockerconfigjson",
+		Data: map[string]string{
+			".dockerconfigjson": b64.StdEncoding.EncodeToString(dockerConfigData),
+		},
+	}, nil
 }
 
-func createSecret(ctx context.Context, binDir string, destDir string, fileName string, secretData PullSecretConfig) (string, error) {
-
-	args := []string{
-		"create",
-		"secret",
-		"docker-registry",
-		secretData.Name,
-		"--namespace", secretData.Namespace,
-		"--docker-server=" + secretData.Server,
-		"--docker-username=" + secretData.Username,
-		"--docker-password=" + secretData.Password,
-		"--dry-run=client",
-		"--output=json"}
-
-	cmd := exec.Command(filepath.Join(binDir, "kubectl"), args...)
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	err := os.MkdirAll(destDir, os.ModePerm)
-	if err != nil {
-		return "", err
+// sealPullSecret seals the pull secret in memory, so the registry credentials are never written to
+// disk, and writes the sealed secret to destDir
+func sealPullSecret(ctx context.Context, destDir string, fileName string, secretData PullSecretConfig, cert string, scope string) error {
+	for _, registry := range secretData.Registries {
+		registerSecret(registry.Password)
+		registerSecret(registry.Auth)
 	}
-	destFile := path.Join(destDir, fileName)
 
-	outfilePipeIn, err := os.Create(destFile)
+	publicKey, err := parseSealingKey(cert)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer func() {
-		if tmpErr := outfilePipeIn.Close(); tmpErr != nil {
-			err = tmpErr
-		}
-	}()
-
-	cmd.Stdout = outfilePipeIn
 
-	stderr, err := cmd.StderrPipe()
+	secret, err := dockerConfigJsonSecret(secretData)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	err = cmd.Start()
+	sealedSecret, err := sealSecret(*secret, publicKey, scope)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	inErr := bufio.NewScanner(stderr)
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
+	sealedSecretYaml, err := marshalDocument(sealedSecret, false)
+	if err != nil {
+		return err
 	}
 
-	err = cmd.Wait()
+	err = os.MkdirAll(destDir, os.ModePerm)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return fileName, err
+	destFile := path.Join(destDir, fileName)
+	tflog.Debug(ctx, "Sealed pull secret written to: "+destFile)
+
+	return os.WriteFile(destFile, sealedSecretYaml, 0644)
 }
@@ -0,0 +1,313 @@
+package gitops
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"gopkg.in/yaml.v3"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSealingCert returns a self-signed certificate like the one of the sealed-secrets controller
+func testSealingCert(t *testing.T) string {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}))
+}
+
+func TestResourceGitopsPullSecretCreateRunsIgc(t *testing.T) {
+	executor := &RecordingExecutor{}
+	tmpDir := t.TempDir()
+
+	d := schema.TestResourceDataRaw(t, resourceGitopsPullSecret().Schema, map[string]interface{}{
+		"name":              "my-pull-secret",
+		"namespace":         "my-namespace",
+		"layer":             "infrastructure",
+		"credentials":       testGitopsCredentials,
+		"config":            testGitopsConfig(t),
+		"kubeseal_cert":     testSealingCert(t),
+		"registry_server":   "quay.io",
+		"registry_username": "robot",
+		"registry_password": "registry-password",
+		"tmp_dir":           tmpDir,
+	})
+
+	// the sealed secret is checked when igc publishes the content dir
+	var sealedSecret SealedSecret
+	executor.Handler = func(ctx context.Context, request CommandRequest) error {
+		var contentDir string
+		for i, arg := range request.Args[:len(request.Args)-1] {
+			if arg == "--contentDir" {
+				contentDir = request.Args[i+1]
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(contentDir, "pull-secret.yaml"))
+		if err != nil {
+			return err
+		}
+
+		if strings.Contains(string(data), "registry-password") {
+			t.Error("the sealed pull secret holds the registry password in plain text")
+		}
+
+		return yaml.Unmarshal(data, &sealedSecret)
+	}
+
+	diags := resourceGitopsPullSecretCreate(context.Background(), d, testProviderConfig(executor))
+	assertNoErrors(t, diags)
+
+	requests := executor.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(requests))
+	}
+
+	request := requests[0]
+	contentDir := filepath.Join(tmpDir, "my-pull-secret", "sealed-secrets")
+
+	expectedArgs := []string{
+		"gitops-module", "my-pull-secret",
+		"-n", "my-namespace",
+		"--branch", "main",
+		"--serverName", "default",
+		"--layer", "infrastructure",
+		"--type", "base",
+		"--contentDir", contentDir,
+		"--caCert", "/certs/ca.crt",
+		"--debug", "false",
+	}
+	if !reflect.DeepEqual(request.Args, expectedArgs) {
+		t.Errorf("unexpected args\nexpected: %v\nactual:   %v", expectedArgs, request.Args)
+	}
+
+	assertEnv(t, request, "GIT_CREDENTIALS", testGitopsCredentials)
+
+	if sealedSecret.Kind != sealedSecretKind || sealedSecret.Spec.Template.Type != "kubernetes.io/dockerconfigjson" {
+		t.Errorf("unexpected sealed secret %+v", sealedSecret)
+	}
+
+	if _, found := sealedSecret.Spec.EncryptedData[".dockerconfigjson"]; !found {
+		t.Error("expected .dockerconfigjson in the encrypted data")
+	}
+
+	// nothing but the sealed secret is written below the tmp dir
+	err := filepath.WalkDir(tmpDir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && filepath.Base(path) != "pull-secret.yaml" {
+			t.Errorf("unexpected file %s", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDockerConfigJsonSecret(t *testing.T) {
+	robotAuth := b64.StdEncoding.EncodeToString([]byte("robot:registry-password"))
+
+	tests := []struct {
+		name       string
+		registries []RegistryCredentials
+		expected   map[string]DockerConfigAuth
+		err        string
+	}{
+		{
+			name:       "single registry",
+			registries: []RegistryCredentials{{Server: "quay.io", Username: "robot", Password: "registry-password"}},
+			expected: map[string]DockerConfigAuth{
+				"quay.io": {Username: "robot", Password: "registry-password", Auth: robotAuth},
+			},
+		},
+		{
+			name: "merged registries",
+			registries: []RegistryCredentials{
+				{Server: "quay.io", Username: "robot", Password: "registry-password"},
+				{Server: "icr.io", Username: "iamapikey", Password: "api-key", Email: "admin@example.com"},
+				{Server: "docker.io", Username: "user", Password: "docker-password"},
+			},
+			expected: map[string]DockerConfigAuth{
+				"quay.io":   {Username: "robot", Password: "registry-password", Auth: robotAuth},
+				"icr.io":    {Username: "iamapikey", Password: "api-key", Email: "admin@example.com", Auth: b64.StdEncoding.EncodeToString([]byte("iamapikey:api-key"))},
+				"docker.io": {Username: "user", Password: "docker-password", Auth: b64.StdEncoding.EncodeToString([]byte("user:docker-password"))},
+			},
+		},
+		{
+			name:       "explicit auth",
+			registries: []RegistryCredentials{{Server: "quay.io", Username: "robot", Password: "registry-password", Auth: "cm9ib3Q6b3RoZXI="}},
+			expected: map[string]DockerConfigAuth{
+				"quay.io": {Username: "robot", Password: "registry-password", Auth: "cm9ib3Q6b3RoZXI="},
+			},
+		},
+		{
+			name: "duplicate server",
+			registries: []RegistryCredentials{
+				{Server: "quay.io", Username: "robot", Password: "registry-password"},
+				{Server: "quay.io", Username: "other", Password: "other-password"},
+			},
+			err: "registry quay.io is defined more than once in the pull secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret, err := dockerConfigJsonSecret(PullSecretConfig{Name: "my-pull-secret", Namespace: "my-namespace", Registries: test.registries})
+
+			if len(test.err) > 0 {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("expected the error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if secret.Type != "kubernetes.io/dockerconfigjson" || secret.Metadata.Name != "my-pull-secret" || secret.Metadata.Namespace != "my-namespace" {
+				t.Errorf("unexpected secret %+v", secret)
+			}
+			if len(secret.Data) != 1 {
+				t.Errorf("expected only .dockerconfigjson in the secret, got %v", secret.Data)
+			}
+
+			data, err := b64.StdEncoding.DecodeString(secret.Data[".dockerconfigjson"])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dockerConfig := DockerConfigJson{}
+			err = json.Unmarshal(data, &dockerConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(dockerConfig.Auths, test.expected) {
+				t.Errorf("unexpected auths\nexpected: %v\nactual:   %v", test.expected, dockerConfig.Auths)
+			}
+		})
+	}
+}
+
+func TestResourceGitopsPullSecretUpdateResealsChangedRegistry(t *testing.T) {
+	executor := newSealedSecretExecutor(t)
+	providerConfig := testProviderConfig(executor)
+	resource := resourceGitopsPullSecret()
+
+	raw := map[string]interface{}{
+		"name":          "my-pull-secret",
+		"namespace":     "my-namespace",
+		"layer":         "infrastructure",
+		"credentials":   testGitopsCredentials,
+		"config":        testGitopsConfig(t),
+		"kubeseal_cert": testSealingCert(t),
+		"registry": []interface{}{
+			map[string]interface{}{"server": "quay.io", "username": "robot", "password": "registry-password"},
+			map[string]interface{}{"server": "icr.io", "username": "iamapikey", "password": "api-key"},
+		},
+		"tmp_dir": t.TempDir(),
+	}
+
+	state, _ := applyTestConfig(t, resource, nil, raw, providerConfig)
+
+	raw["registry"] = []interface{}{
+		map[string]interface{}{"server": "quay.io", "username": "robot", "password": "registry-password"},
+		map[string]interface{}{"server": "icr.io", "username": "iamapikey", "password": "new-api-key"},
+	}
+	_, diff := applyTestConfig(t, resource, state, raw, providerConfig)
+
+	if diff.RequiresNew() {
+		t.Error("expected the pull secret to be updated in place")
+	}
+	if len(executor.published) != 2 {
+		t.Fatalf("expected the pull secret to be published again, got %d commands", len(executor.published))
+	}
+
+	before := executor.published[0]["pull-secret.yaml"].Spec.EncryptedData[".dockerconfigjson"]
+	after := executor.published[1]["pull-secret.yaml"].Spec.EncryptedData[".dockerconfigjson"]
+	if len(after) == 0 || after == before {
+		t.Error("expected the changed registry to be sealed again")
+	}
+}
+
+func TestResourceGitopsPullSecretDelete(t *testing.T) {
+	executor := &RecordingExecutor{}
+	tmpDir := t.TempDir()
+
+	// the pull secret is removed with the identity in the state, without a cert or credentials
+	d := schema.TestResourceDataRaw(t, resourceGitopsPullSecret().Schema, map[string]interface{}{
+		"name":        "my-pull-secret",
+		"namespace":   "my-namespace",
+		"layer":       "infrastructure",
+		"credentials": testGitopsCredentials,
+		"config":      testGitopsConfig(t),
+		"tmp_dir":     tmpDir,
+	})
+	d.SetId("my-namespace:my-pull-secret:default:infrastructure:base")
+
+	// a pull secret left by create is published as is
+	contentDir := filepath.Join(tmpDir, "my-pull-secret", "sealed-secrets")
+	writeTestFile(t, contentDir, "pull-secret.yaml", "kind: SealedSecret\n")
+
+	executor.Handler = func(ctx context.Context, request CommandRequest) error {
+		data, err := os.ReadFile(filepath.Join(contentDir, "pull-secret.yaml"))
+		if err != nil {
+			return err
+		}
+
+		if string(data) != "kind: SealedSecret\n" {
+			t.Error("expected the pull secret not to be sealed again on delete")
+		}
+
+		return nil
+	}
+
+	diags := resourceGitopsPullSecretDelete(context.Background(), d, testProviderConfig(executor))
+	assertNoErrors(t, diags)
+
+	requests := executor.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(requests))
+	}
+	if args := strings.Join(requests[0].Args, " "); !strings.HasPrefix(args, "gitops-module my-pull-secret") || !strings.Contains(args, "--delete") {
+		t.Errorf("expected the module to be deleted, got %s", args)
+	}
+	for _, request := range requests {
+		if request.Name != "igc" {
+			t.Errorf("expected no seal command, got %s", request.Name)
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(tmpDir, "my-pull-secret"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected the tmp dir to be removed, got %v", err)
+	}
+	if d.Id() != "" {
+		t.Errorf("expected the id to be cleared, got %s", d.Id())
+	}
+}
@@ -11,17 +11,21 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"math/rand"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strings"
 )
 
 func resourceGitopsRepo() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsRepoCreate,
-		ReadContext:   resourceGitopsRepoRead,
-		UpdateContext: resourceGitopsRepoUpdate,
-		DeleteContext: resourceGitopsRepoDelete,
-		Schema: map[string]*schema.Schema{
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsRepoCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsRepoRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsRepoUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsRepoDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsRepoImport,
+		},
+		CustomizeDiff: resourceGitopsRepoCustomizeDiff,
+		Timeouts:      gitopsResourceTimeouts(),
+		Schema: withSealingCertSchema(map[string]*schema.Schema{
 			"repo_url": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -96,10 +100,12 @@ func resourceGitopsRepo() *schema.Resource {
 				Default:     "openshift-gitops",
 			},
 			"sealed_secrets_cert": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Description: "The certificate/public key used to encrypt the sealed secrets.",
-				Default:     "",
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "The certificate/public key used to encrypt the sealed secrets. When empty the cli generates the certificate and the generated certificate is kept in the state.",
+				Default:          "",
+				ValidateFunc:     validateSealingCert,
+				DiffSuppressFunc: suppressGeneratedSealingCert,
 			},
 			"public": {
 				Type:        schema.TypeBool,
@@ -146,9 +152,9 @@ func resourceGitopsRepo() *schema.Resource {
 				Sensitive:   true,
 			},
 			"result_host": {
-			    Type:        schema.TypeString,
-			    Computed:    true,
-			    Description: "The host that will be used for the git repo.",
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The host that will be used for the git repo.",
 			},
 			"result_org": {
 				Type:        schema.TypeString,
@@ -161,15 +167,15 @@ func resourceGitopsRepo() *schema.Resource {
 				Description: "The project that will be used for the git repo.",
 			},
 			"result_username": {
-			    Type:        schema.TypeString,
-			    Computed:    true,
-			    Description: "The username that will be used to access the git repo.",
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The username that will be used to access the git repo.",
 			},
 			"result_token": {
-			    Type:        schema.TypeString,
-			    Computed:    true,
-			    Description: "The token that will be used to access the git repo.",
-			    Sensitive:   true,
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The token that will be used to access the git repo.",
+				Sensitive:   true,
 			},
 			"result_branch": {
 				Type:        schema.TypeString,
@@ -191,8 +197,8 @@ func resourceGitopsRepo() *schema.Resource {
 				Computed:    true,
 				Description: "Name of the file containing the ca certificate for SSL connections.",
 			},
-		},
-	}
+		}, "sealed_secrets_cert"),
+	})
 }
 
 type GitopsRepoConfig struct {
@@ -211,7 +217,6 @@ type GitopsRepoConfig struct {
 	Public            bool   `yaml:"public"`
 	Strict            bool   `yaml:"strict"`
 	TmpDir            string `yaml:"tmp_dir"`
-	BinDir            string `yaml:"bin_dir"`
 	Debug             bool   `yaml:"debug"`
 }
 
@@ -283,6 +288,11 @@ func resourceGitopsRepoCreate(ctx context.Context, d *schema.ResourceData, m int
 
 	config := m.(*ProviderConfig)
 
+	err := config.checkIgcEngine("gitops_repo")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	gitConfig, err := loadGitConfigValues(ctx, d, "")
 	if err != nil {
 		return diag.FromErr(err)
@@ -312,12 +322,11 @@ func resourceGitopsRepoCreate(ctx context.Context, d *schema.ResourceData, m int
 		SealedSecretsCert: d.Get("sealed_secrets_cert").(string),
 		Strict:            d.Get("strict").(bool),
 		TmpDir:            d.Get("tmp_dir").(string),
-		BinDir:            config.BinDir,
 	}
 
-	result, err := processGitopsRepo(ctx, gitopsRepoConfig, false)
+	result, err := processGitopsRepo(ctx, config, gitopsRepoConfig, false)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	suffix := randStringBytes(16)
@@ -351,6 +360,11 @@ func resourceGitopsRepoCreate(ctx context.Context, d *schema.ResourceData, m int
 		return diag.FromErr(err)
 	}
 
+	err = setSealingCertValues(d, "sealed_secrets_cert")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	gitopsConfigJson, err := toJson(result.GitopsConfig)
 	if err != nil {
 		return diag.FromErr(err)
@@ -435,13 +449,164 @@ func resourceGitopsRepoRead(ctx context.Context, d *schema.ResourceData, m inter
 
 	tflog.Info(ctx, "Reading gitops-repo")
 
+	url := d.Get("url").(string)
+	if len(url) == 0 {
+		return diags
+	}
+
+	credential := &GitCredential{
+		Url:      url,
+		Username: d.Get("result_username").(string),
+		Token:    d.Get("result_token").(string),
+	}
+
+	exists, err := gitRepoExists(ctx, url, credential, d.Get("result_ca_cert_file").(string))
+	if err != nil {
+		return gitopsReadWarning("Unable to read the gitops repo", err)
+	}
+
+	if !exists {
+		tflog.Warn(ctx, fmt.Sprintf("Gitops repo %s not found. Removing repo from state", url))
+
+		d.SetId("")
+	}
+
 	return diags
 }
 
+// resourceGitopsRepoImport adopts an existing gitops repo using a host/org/repo or
+// host/org/project/repo import id. The repo is not created by terraform so it is not deleted on
+// destroy. The credentials, server name and ca cert are taken from the provider config and the
+// gitops config is built for the standard layout.
+func resourceGitopsRepoImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	importId := d.Id()
+
+	parts := strings.Split(importId, "/")
+	if len(parts) != 3 && len(parts) != 4 {
+		return nil, fmt.Errorf("unexpected format of import id (%s), expected host/org/repo or host/org/project/repo", importId)
+	}
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, fmt.Errorf("unexpected format of import id (%s), expected host/org/repo or host/org/project/repo", importId)
+		}
+	}
+
+	host := parts[0]
+	org := parts[1]
+	project := ""
+	repo := parts[len(parts)-1]
+	if len(parts) == 4 {
+		project = parts[2]
+	}
+
+	gitConfig := config.GitConfig
+	url := gitRepoUrl(host, org, project, repo)
+
+	credential := &GitCredential{
+		Url:      url,
+		Repo:     gitRepoSlug(url),
+		Username: gitConfig.Username,
+		Token:    gitConfig.Token,
+	}
+
+	exists, err := gitRepoExists(ctx, url, credential, gitConfig.CaCertFile)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("gitops repo %s not found", url)
+	}
+
+	gitopsConfigJson, err := toJson(defaultGitopsConfig(url, config.ServerName))
+	if err != nil {
+		return nil, err
+	}
+
+	gitCredentialJson, err := toJson([]GitCredential{*credential})
+	if err != nil {
+		return nil, err
+	}
+
+	err = setImportDefaults(d, resourceGitopsRepo().Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setResourceValues(d, map[string]interface{}{
+		"host":                host,
+		"org":                 org,
+		"project":             project,
+		"repo":                repo,
+		"created":             false,
+		"url":                 url,
+		"repo_slug":           credential.Repo,
+		"gitops_config":       gitopsConfigJson,
+		"git_credentials":     gitCredentialJson,
+		"result_host":         host,
+		"result_org":          org,
+		"result_project":      project,
+		"result_username":     gitConfig.Username,
+		"result_token":        gitConfig.Token,
+		"result_branch":       config.Branch,
+		"result_server_name":  config.ServerName,
+		"result_ca_cert_file": gitConfig.CaCertFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = setSealingCertValues(d, "sealed_secrets_cert")
+	if err != nil {
+		return nil, err
+	}
+
+	dat, err := os.ReadFile(gitConfig.CaCertFile)
+	if err == nil {
+		err = d.Set("result_ca_cert", string(dat))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.SetId(fmt.Sprintf("%s:%s", importId, randStringBytes(16)))
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// resourceGitopsRepoCustomizeDiff plans the fingerprint and expiry of the sealed secrets cert. The
+// values are derived from the cert stored in the state, which is only known after the apply when
+// the cli generates the cert.
+func resourceGitopsRepoCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	oldCert, newCert := d.GetChange("sealed_secrets_cert")
+	if !d.NewValueKnown("sealed_secrets_cert") || len(newCert.(string)) > 0 {
+		return customizeSealingCertDiff(d, "sealed_secrets_cert")
+	}
+
+	// without a configured cert the state keeps the cert generated by the cli on create
+	if len(d.Id()) == 0 {
+		return setNewComputed(d, sealingCertKeys("sealed_secrets_cert")...)
+	}
+
+	return setNewSealingCertValues(d, "sealed_secrets_cert", oldCert.(string))
+}
+
+// suppressGeneratedSealingCert keeps the cert generated by the cli in the state when no
+// sealed_secrets_cert is configured
+func suppressGeneratedSealingCert(k, old, new string, d *schema.ResourceData) bool {
+	return len(new) == 0
+}
+
 func resourceGitopsRepoUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 
 	tflog.Info(ctx, "Updating gitops-repo")
 
+	err := setSealingCertValues(d, "sealed_secrets_cert")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	return resourceGitopsRepoRead(ctx, d, m)
 }
 
@@ -486,12 +651,11 @@ func resourceGitopsRepoDelete(ctx context.Context, d *schema.ResourceData, m int
 		SealedSecretsCert: d.Get("sealed_secrets_cert").(string),
 		Strict:            d.Get("strict").(bool),
 		TmpDir:            d.Get("tmp_dir").(string),
-		BinDir:            config.BinDir,
 	}
 
-	_, err = processGitopsRepo(ctx, gitopsRepoConfig, true)
+	_, err = processGitopsRepo(ctx, config, gitopsRepoConfig, true)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId("")
@@ -499,14 +663,36 @@ func resourceGitopsRepoDelete(ctx context.Context, d *schema.ResourceData, m int
 	return diags
 }
 
-func processGitopsRepo(ctx context.Context, config GitopsRepoConfig, delete bool) (*GitopsRepoResult, error) {
+func processGitopsRepo(ctx context.Context, providerConfig *ProviderConfig, config GitopsRepoConfig, delete bool) (*GitopsRepoResult, error) {
 
-	// this should be replaced with the actual git user
-	mutexKey := fmt.Sprintf("%s/%s/%s:%s", config.Host, config.Org, config.Repo, config.Project)
+	repoUrl := config.Url
+	if len(repoUrl) == 0 {
+		repoUrl = gitRepoUrl(config.Host, config.Org, config.Project, config.Repo)
+	}
 
-	gitopsMutexKV.Lock(mutexKey)
+	lockKey := gitRepoLockKey(repoUrl, config.Branch)
 
-	defer gitopsMutexKV.Unlock(mutexKey)
+	unlock, err := lockGitRepo(ctx, lockKey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	outputFile, cleanup, err := commandOutputFile("gitops-init")
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	// igc gitops-init clones the repo into tmp_dir with the git token in the remote url. The delete
+	// still runs with a tmp_dir left readable by earlier versions, so the repo can be destroyed.
+	if !delete {
+		err = providerConfig.preparePrivateDir("the git token used by igc gitops-init", config.TmpDir)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	tflog.Info(ctx, fmt.Sprintf("Provisioning gitops repo: host=%s, org=%s, project=%s, repo=%s", config.Host, config.Org, config.Project, config.Repo))
 
@@ -515,7 +701,7 @@ func processGitopsRepo(ctx context.Context, config GitopsRepoConfig, delete bool
 		args = []string{
 			"gitops-init",
 			config.Url,
-			"--output", "jsonfile=./output.json",
+			"--output", "jsonfile=" + outputFile,
 			"--branch", config.Branch,
 			"--serverName", config.ServerName,
 			"--tmpDir", config.TmpDir,
@@ -525,7 +711,7 @@ func processGitopsRepo(ctx context.Context, config GitopsRepoConfig, delete bool
 		args = []string{
 			"gitops-init",
 			config.Repo,
-			"--output", "jsonfile=./output.json",
+			"--output", "jsonfile=" + outputFile,
 			"--host", config.Host,
 			"--org", config.Org,
 			"--branch", config.Branch,
@@ -561,55 +747,37 @@ func processGitopsRepo(ctx context.Context, config GitopsRepoConfig, delete bool
 		args = append(args, "--delete")
 	}
 
-	cmd := exec.Command(filepath.Join(config.BinDir, "igc"), args...)
-
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
-
-	envNames := []string{"GIT_USERNAME", "GIT_TOKEN"}
-
-	updatedEnv := append(os.Environ(), "GIT_USERNAME="+config.Username)
-	updatedEnv = append(updatedEnv, "GIT_TOKEN="+config.Token)
+	env := []string{
+		"GIT_USERNAME=" + config.Username,
+		"GIT_TOKEN=" + config.Token,
+	}
 	if len(config.SealedSecretsCert) > 0 {
-		envNames = append(envNames, "KUBESEAL_CERT")
-		updatedEnv = append(updatedEnv, "KUBESEAL_CERT="+config.SealedSecretsCert)
+		env = append(env, "KUBESEAL_CERT="+config.SealedSecretsCert)
 	}
 
-	logEnvironment(ctx, &updatedEnv)
-	cmd.Env = updatedEnv
+	var outb bytes.Buffer
 
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-
-	// start the command after having set up the pipe
-	if err := cmd.Start(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error starting command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
-		return nil, err
-	}
-
-	if err := cmd.Wait(); err != nil {
-		tflog.Error(ctx, fmt.Sprintf("Error running command: %s", fmt.Sprintln(err)))
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errb.String()))
+	err = providerConfig.Executor.Execute(ctx, CommandRequest{
+		Name:   "igc",
+		Args:   args,
+		Env:    env,
+		Stdout: &outb,
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	outText := outb.String()
 	if len(outText) > 0 {
-		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", outText))
-	}
-
-	errText := errb.String()
-	if len(errText) > 0 {
-		tflog.Error(ctx, fmt.Sprintf("Command error log: %s", errText))
+		tflog.Debug(ctx, fmt.Sprintf("Command standard log: %s", redact(outText)))
 	}
 
-	dat, err := os.ReadFile("./output.json")
+	dat, err := os.ReadFile(outputFile)
 	if err != nil {
 		return nil, err
 	}
 
-	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops repo: %s", string(dat)))
+	tflog.Debug(ctx, fmt.Sprintf("JSON result from gitops repo: %s", redact(string(dat))))
 
 	repoResult := GitopsRepoResult{}
 	err = json.Unmarshal(dat, &repoResult)
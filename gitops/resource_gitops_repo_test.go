@@ -0,0 +1,143 @@
+package gitops
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"encoding/json"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitopsInitExecutor answers igc gitops-init with a repo sealed with the cert
+func gitopsInitExecutor(t *testing.T, cert string) *RecordingExecutor {
+	return &RecordingExecutor{
+		Handler: func(ctx context.Context, request CommandRequest) error {
+			for _, arg := range request.Args {
+				if !strings.HasPrefix(arg, "jsonfile=") {
+					continue
+				}
+
+				dat, err := json.Marshal(GitopsRepoResult{
+					Url:          "https://github.com/org/gitops",
+					Repo:         "gitops",
+					Created:      true,
+					KubesealCert: cert,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				return os.WriteFile(strings.TrimPrefix(arg, "jsonfile="), dat, 0600)
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestResourceGitopsRepoSealingCertValues(t *testing.T) {
+	cert := testSealingCert(t)
+
+	expected, err := sealingCertValues("sealed_secrets_cert", cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, configuredCert := range []string{"", cert} {
+		name := "configured cert"
+		if len(configuredCert) == 0 {
+			name = "generated cert"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			providerConfig := testProviderConfig(gitopsInitExecutor(t, cert))
+			resource := resourceGitopsRepo()
+
+			raw := map[string]interface{}{
+				"host":                "github.com",
+				"org":                 "org",
+				"repo":                "gitops",
+				"username":            "admin",
+				"token":               "gitops-token",
+				"branch":              "main",
+				"server_name":         "default",
+				"sealed_secrets_cert": configuredCert,
+				"tmp_dir":             filepath.Join(t.TempDir(), "gitops-init"),
+			}
+
+			state, diff := applyTestConfig(t, resource, nil, raw, providerConfig)
+
+			for key, value := range expected {
+				attribute := diff.Attributes[key]
+				if attribute == nil {
+					t.Fatalf("expected %s to be planned", key)
+				}
+				// the generated cert is only known after the apply
+				if len(configuredCert) == 0 && !attribute.NewComputed {
+					t.Errorf("expected %s to be computed, got %+v", key, attribute)
+				}
+				if len(configuredCert) > 0 && attribute.New != value {
+					t.Errorf("expected %s to be planned as %v, got %+v", key, value, attribute)
+				}
+
+				if state.Attributes[key] != value {
+					t.Errorf("expected %s = %v, got %s", key, value, state.Attributes[key])
+				}
+			}
+			if state.Attributes["sealed_secrets_cert"] != cert {
+				t.Error("expected the cert of the repo in the state")
+			}
+
+			diff, err := resource.Diff(context.Background(), state, terraform.NewResourceConfigRaw(raw), providerConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !diff.Empty() {
+				t.Errorf("expected a clean plan after the apply, got %v", diff.Attributes)
+			}
+		})
+	}
+}
+
+func TestResourceGitopsRepoCaCert(t *testing.T) {
+	workDir := t.TempDir()
+	testChdir(t, workDir)
+
+	executor := gitopsInitExecutor(t, testSealingCert(t))
+	providerConfig := testProviderConfig(executor)
+	providerConfig.ForbidPlaintextOnDisk = true
+	resource := resourceGitopsRepo()
+
+	state, _ := applyTestConfig(t, resource, nil, map[string]interface{}{
+		"host":        "github.com",
+		"org":         "org",
+		"repo":        "gitops",
+		"username":    "admin",
+		"token":       "gitops-token",
+		"branch":      "main",
+		"server_name": "default",
+		"ca_cert":     b64.StdEncoding.EncodeToString([]byte("-----BEGIN CERTIFICATE-----\n")),
+		"tmp_dir":     filepath.Join(t.TempDir(), "gitops-init"),
+	}, providerConfig)
+
+	caCertFile := filepath.Join(workDir, "git-ca.crt")
+	if state.Attributes["result_ca_cert_file"] != caCertFile {
+		t.Errorf("expected the ca cert to be written to %s, got %s", caCertFile, state.Attributes["result_ca_cert_file"])
+	}
+
+	diags := resourceGitopsRepoDelete(context.Background(), resource.Data(state), providerConfig)
+	assertNoErrors(t, diags)
+
+	requests := executor.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected the repo to be created and deleted, got %d commands", len(requests))
+	}
+	for _, request := range requests {
+		if !strings.Contains(strings.Join(request.Args, " "), "--caCertFile "+caCertFile) {
+			t.Errorf("expected the ca cert file to be passed to igc, got %v", request.Args)
+		}
+	}
+}
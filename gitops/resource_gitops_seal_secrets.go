@@ -1,289 +1,495 @@
 package gitops
 
 import (
-	"bufio"
 	"context"
+	"crypto/rsa"
 	"fmt"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
-	"io"
-	"io/ioutil"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
+	"reflect"
 )
 
 func resourceGitopsSealSecrets() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsSealSecretsCreate,
-		ReadContext:   resourceGitopsSealSecretsRead,
-		UpdateContext: resourceGitopsSealSecretsUpdate,
-		DeleteContext: resourceGitopsSealSecretsDelete,
-		Schema: map[string]*schema.Schema{
-			"source_dir": {
-				Type:     schema.TypeString,
-				Required: true,
-			},
-			"dest_dir": {
-				Type:     schema.TypeString,
-				Required: true,
-			},
-			"kubeseal_cert": {
-				Type:     schema.TypeString,
-				Required: true,
-			},
-			"annotations": {
-				Type:        schema.TypeList,
-				Optional:    true,
-				Description: "The list of annotations that should be added to the generated Sealed Secrets. Expected format of each annotation is a string if 'key=value'",
-				Elem:        &schema.Schema{Type: schema.TypeString},
-			},
-			"tmp_dir": {
-				Type:        schema.TypeString,
-				Optional:    true,
-				Default:     ".tmp/sealed-secrets",
-				Description: "The temporary directory where the cert will be written",
+	return withResourceDiagnostics(&schema.Resource{
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Version: 0,
+				Type:    resourceGitopsSealSecretsV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: resourceGitopsSealSecretsStateUpgradeV0,
 			},
 		},
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsSealSecretsCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsSealSecretsRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsSealSecretsUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsSealSecretsDelete),
+		CustomizeDiff:        resourceGitopsSealSecretsCustomizeDiff,
+		Timeouts:             gitopsResourceTimeouts(),
+		Schema:               withSealingCertSchema(resourceGitopsSealSecretsSchema(), "kubeseal_cert"),
+	})
+}
+
+func resourceGitopsSealSecretsSchema() map[string]*schema.Schema {
+	return map[string]*schema.Schema{
+		"source_dir": {
+			Type:     schema.TypeString,
+			Required: true,
+			ForceNew: true,
+		},
+		"dest_dir": {
+			Type:     schema.TypeString,
+			Required: true,
+			ForceNew: true,
+		},
+		"kubeseal_cert": {
+			Type:         schema.TypeString,
+			Required:     true,
+			Description:  "The PEM encoded certificate of the sealed-secrets controller used to seal the secrets",
+			ValidateFunc: validateSealingCert,
+		},
+		"annotations": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "The annotations that should be added to the metadata of the generated Sealed Secrets.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"labels": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "The labels that should be added to the metadata of the generated Sealed Secrets.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"template_annotations": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "The annotations that should be added to the template metadata of the Sealed Secrets, i.e. to the Secrets created when they are unsealed.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"template_labels": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Description: "The labels that should be added to the template metadata of the Sealed Secrets, i.e. to the Secrets created when they are unsealed.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"scope": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			Description:  "The scope of the sealed secrets: strict, namespace-wide or cluster-wide. A strict secret can only be unsealed with the same name and namespace, a namespace-wide secret can be renamed and a cluster-wide secret can be moved to any namespace. If not provided the scope is taken from the sealedsecrets.bitnami.com annotations of each secret, otherwise strict.",
+			ValidateFunc: validation.StringInSlice(sealedSecretScopes, false),
+		},
+		"include": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Glob patterns of the files in source_dir that will be sealed, e.g. 'apps/**/*.yaml'. A pattern without a slash matches the file name in any directory. If not provided every .yaml, .yml and .json file is sealed.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"exclude": {
+			Type:        schema.TypeList,
+			Optional:    true,
+			Description: "Glob patterns of the files in source_dir that will not be sealed, using the same format as include.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"pass_through_non_secrets": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Default:     false,
+			Description: "Flag indicating that documents other than Secrets should be copied to dest_dir unchanged. If false a file containing any other kind of resource is rejected.",
+		},
+		"file_hashes": {
+			Type:        schema.TypeMap,
+			Computed:    true,
+			Description: "The digest of each sealed file, keyed by the path relative to source_dir, computed from the contents of the file and the seal settings with an HMAC keyed by file_hash_key. Used to re-seal only the files that changed.",
+			Elem:        &schema.Schema{Type: schema.TypeString},
+		},
+		"file_hash_key": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Sensitive:   true,
+			Description: "The random key, generated for the resource, of the HMAC used to compute file_hashes.",
+		},
+		"rotation_trigger": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "An arbitrary value that re-seals every file when it changes, e.g. after the sealed-secrets controller rotated its key.",
+		},
+		"sealed_digest": {
+			Type:        schema.TypeString,
+			Computed:    true,
+			Description: "The digest of the sealed files written to dest_dir. It is unknown in a plan that re-seals any file, so a gitops_module whose content_trigger is set to it publishes the re-sealed files in the same apply.",
+		},
+		"tmp_dir": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Default:     ".tmp/sealed-secrets",
+			Description: "The temporary directory where the cert was written. The secrets are now sealed in-process so the directory is no longer used.",
+			Deprecated:  "The secrets are sealed in-process and the cert is no longer written to disk.",
+		},
+	}
+}
+
+// resourceValues is implemented by both schema.ResourceData and schema.ResourceDiff
+type resourceValues interface {
+	Get(key string) interface{}
+}
+
+type SealSecretsConfig struct {
+	SourceDir       string
+	DestDir         string
+	Cert            string
+	RotationTrigger string
+	HashKey         string
+	Options         SealOptions
+	Filter          FileFilter
+}
+
+func getSealSecretsConfig(d resourceValues) *SealSecretsConfig {
+	return &SealSecretsConfig{
+		SourceDir:       d.Get("source_dir").(string),
+		DestDir:         d.Get("dest_dir").(string),
+		Cert:            d.Get("kubeseal_cert").(string),
+		RotationTrigger: d.Get("rotation_trigger").(string),
+		HashKey:         d.Get("file_hash_key").(string),
+		Options: SealOptions{
+			Scope:               d.Get("scope").(string),
+			Annotations:         interfacesToStringMap(d.Get("annotations").(map[string]interface{})),
+			Labels:              interfacesToStringMap(d.Get("labels").(map[string]interface{})),
+			TemplateAnnotations: interfacesToStringMap(d.Get("template_annotations").(map[string]interface{})),
+			TemplateLabels:      interfacesToStringMap(d.Get("template_labels").(map[string]interface{})),
+			PassThrough:         d.Get("pass_through_non_secrets").(bool),
+		},
+		Filter: FileFilter{
+			Include: interfacesToStrings(d.Get("include").([]interface{})),
+			Exclude: interfacesToStrings(d.Get("exclude").([]interface{})),
+		},
+	}
+}
+
+// fileDigests returns the digest of every file in the source dir selected by the filter
+func (c *SealSecretsConfig) fileDigests() (map[string]string, error) {
+	files, err := listSecretFiles(c.SourceDir, c.Filter)
+	if err != nil {
+		return nil, err
 	}
+
+	return sealedFileDigests(c.SourceDir, files, c.HashKey, sealSettingsDigest(c.Cert, c.RotationTrigger, c.Options))
+}
+
+// setFileHashKey generates the key of the file digests when the resource has none yet, i.e. on create
+// and on the first update of a resource created before the digests were keyed
+func (c *SealSecretsConfig) setFileHashKey(d *schema.ResourceData) error {
+	if len(c.HashKey) > 0 {
+		return nil
+	}
+
+	hashKey, err := newFileHashKey()
+	if err != nil {
+		return err
+	}
+
+	c.HashKey = hashKey
+
+	return d.Set("file_hash_key", hashKey)
 }
 
 func resourceGitopsSealSecretsCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
-	config := m.(*ProviderConfig)
-
-	sourceDir := d.Get("source_dir").(string)
-	destDir := d.Get("dest_dir").(string)
-	cert := d.Get("kubeseal_cert").(string)
-	annotations := interfacesToStrings(d.Get("annotations").([]interface{}))
-	tmpDir := d.Get("tmp_dir").(string)
+	sealConfig := getSealSecretsConfig(d)
 
-	binDir := config.BinDir
+	publicKey, err := parseSealingKey(sealConfig.Cert)
+	if err != nil {
+		return errorDiagnostics(err)
+	}
 
-	certFile, err := writeCertFile(ctx, tmpDir, cert)
+	err = os.MkdirAll(sealConfig.DestDir, os.ModePerm)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
-	var baseArgs = []string{
-		"--cert", certFile,
-		"--format", "yaml"}
-
-	err = os.MkdirAll(destDir, os.ModePerm)
+	err = sealConfig.setFileHashKey(d)
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
-	files, err := ioutil.ReadDir(sourceDir)
+	fileDigests, err := sealConfig.fileDigests()
 	if err != nil {
 		return diag.FromErr(err)
 	}
 
-	for _, file := range files {
-		if !strings.HasSuffix(file.Name(), "yaml") {
-			tflog.Debug(ctx, "Skipping file because it is not a yaml file: "+file.Name())
-			continue
+	for _, file := range sortedKeys(fileDigests) {
+		tflog.Info(ctx, "Encrypting file: "+file)
+
+		result, err := encryptFile(ctx, publicKey, sealConfig.SourceDir, sealConfig.DestDir, file, sealConfig.Options)
+		if err != nil {
+			return diag.FromErr(err)
 		}
 
-		tflog.Info(ctx, "Encrypting file: "+file.Name())
+		tflog.Debug(ctx, "Sealed file written to: "+result)
+	}
 
-		if len(annotations) == 0 {
-			tflog.Debug(ctx, "Encrypting file without annotations")
+	d.SetId("sealCert:" + sealConfig.SourceDir + ":" + sealConfig.DestDir)
 
-			result, err := encryptFile(ctx, baseArgs, binDir, sourceDir, destDir, file.Name())
-			if err != nil {
-				return diag.FromErr(err)
-			}
+	err = setSealedFileValues(d, sealConfig.DestDir, fileDigests)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-			tflog.Debug(ctx, "Sealed file written to: "+result)
-		} else {
-			tflog.Debug(ctx, "Encrypting file with annotations")
+	err = setSealingCertValues(d, "kubeseal_cert")
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-			result, err := encryptFileWithAnnotations(ctx, baseArgs, binDir, sourceDir, destDir, file.Name(), annotations)
-			if err != nil {
-				return diag.FromErr(err)
-			}
+	return diags
+}
 
-			tflog.Debug(ctx, "Sealed file written to: "+result)
-		}
+// setSealedFileValues records the digests of the source files and the digest of the sealed files
+func setSealedFileValues(d *schema.ResourceData, destDir string, fileDigests map[string]string) error {
+	sealedDigest, err := sealedOutputDigest(destDir, sortedKeys(fileDigests))
+	if err != nil {
+		return err
 	}
 
-	d.SetId("sealCert:" + sourceDir + ":" + destDir)
-
-	return diags
+	return setResourceValues(d, map[string]interface{}{
+		"file_hashes":   fileDigests,
+		"sealed_digest": sealedDigest,
+	})
 }
 
+// resourceGitopsSealSecretsRead drops the files that are missing from dest_dir from file_hashes so
+// the next plan re-seals them
 func resourceGitopsSealSecretsRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
+	destDir := d.Get("dest_dir").(string)
+	fileDigests := d.Get("file_hashes").(map[string]interface{})
+
+	result := map[string]interface{}{}
+	for file, digest := range fileDigests {
+		if !fileExists(filepath.Join(destDir, file)) {
+			tflog.Info(ctx, "Sealed file is missing from the dest dir: "+file)
+			continue
+		}
+
+		result[file] = digest
+	}
+
+	err := d.Set("file_hashes", result)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
 	return diags
 }
 
+// resourceGitopsSealSecretsUpdate re-seals the files whose digest changed, seals the new files and
+// removes the sealed files whose source is gone
 func resourceGitopsSealSecretsUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	// TODO implement update...
-	return resourceGitopsModuleRead(ctx, d, m)
-}
-
-func resourceGitopsSealSecretsDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// Warning or errors can be collected in a slice type
 	var diags diag.Diagnostics
 
-	d.SetId("")
-
-	return diags
-}
+	sealConfig := getSealSecretsConfig(d)
 
-func encryptWithCert(ctx context.Context, binDir string, tmpDir string, sourceDir string, destDir string, fileName string, cert string) (string, error) {
-	certFile, err := writeCertFile(ctx, tmpDir, cert)
+	publicKey, err := parseSealingKey(sealConfig.Cert)
 	if err != nil {
-		return "", err
+		return errorDiagnostics(err)
 	}
 
-	var baseArgs = []string{
-		"--cert", certFile,
-		"--format", "yaml"}
+	err = sealConfig.setFileHashKey(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-	err = os.MkdirAll(destDir, os.ModePerm)
+	fileDigests, err := sealConfig.fileDigests()
 	if err != nil {
-		return "", err
+		return diag.FromErr(err)
 	}
 
-	return encryptFile(ctx, baseArgs, binDir, sourceDir, destDir, fileName)
-}
+	oldValue, _ := d.GetChange("file_hashes")
+	oldDigests := oldValue.(map[string]interface{})
 
-func encryptFile(ctx context.Context, args []string, binDir string, sourceDir string, destDir string, fileName string) (string, error) {
-	sourceFile := fmt.Sprintf("%s/%s", sourceDir, fileName)
-	tflog.Debug(ctx, "Reading file contents: "+sourceFile)
+	for _, file := range sortedKeys(fileDigests) {
+		if oldDigests[file] == fileDigests[file] && fileExists(filepath.Join(sealConfig.DestDir, file)) {
+			tflog.Debug(ctx, "Sealed file is up to date: "+file)
+			continue
+		}
 
-	f, err := os.Open(sourceFile)
-	if err != nil {
-		return "", err
-	}
-	fReader := bufio.NewReader(f)
+		tflog.Info(ctx, "Encrypting file: "+file)
 
-	destFile := fmt.Sprintf("%s/%s", destDir, fileName)
-	tflog.Debug(ctx, "Encrypted secret destination file: "+destFile)
+		result, err := encryptFile(ctx, publicKey, sealConfig.SourceDir, sealConfig.DestDir, file, sealConfig.Options)
+		if err != nil {
+			return diag.FromErr(err)
+		}
 
-	cmd := exec.Command(filepath.Join(binDir, "kubeseal"), args...)
-	tflog.Debug(ctx, "Executing command: "+cmd.String())
+		tflog.Debug(ctx, "Sealed file written to: "+result)
+	}
 
-	outfilePipeIn, err := os.Create(destFile)
-	if err != nil {
-		return "", err
+	removedFiles := []string{}
+	for file := range oldDigests {
+		if _, found := fileDigests[file]; !found {
+			removedFiles = append(removedFiles, file)
+		}
 	}
-	defer outfilePipeIn.Close()
 
-	cmd.Stdin = fReader
-	cmd.Stdout = outfilePipeIn
+	tflog.Info(ctx, fmt.Sprintf("Removing %d sealed files whose source was removed", len(removedFiles)))
 
-	stderr, err := cmd.StderrPipe()
+	err = removeSealedFiles(sealConfig.DestDir, removedFiles)
 	if err != nil {
-		return "", err
+		return diag.FromErr(err)
 	}
 
-	err = cmd.Start()
+	err = setSealedFileValues(d, sealConfig.DestDir, fileDigests)
 	if err != nil {
-		return "", err
+		return diag.FromErr(err)
 	}
 
-	inErr := bufio.NewScanner(stderr)
-	for inErr.Scan() {
-		tflog.Error(ctx, inErr.Text())
+	err = setSealingCertValues(d, "kubeseal_cert")
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
-	err = cmd.Wait()
+	return diags
+}
+
+// resourceGitopsSealSecretsDelete removes the sealed files written by the resource
+func resourceGitopsSealSecretsDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// Warning or errors can be collected in a slice type
+	var diags diag.Diagnostics
+
+	destDir := d.Get("dest_dir").(string)
+	fileDigests := d.Get("file_hashes").(map[string]interface{})
+
+	err := removeSealedFiles(destDir, sortedKeys(fileDigests))
 	if err != nil {
-		return "", err
+		return diag.FromErr(err)
 	}
 
-	return destFile, nil
-}
+	d.SetId("")
 
-func encryptFileWithAnnotations(ctx context.Context, args []string, binDir string, sourceDir string, destDir string, fileName string, annotations []string) (string, error) {
-	sourceFile := fmt.Sprintf("%s/%s", sourceDir, fileName)
-	tflog.Debug(ctx, "Reading file contents: "+sourceFile)
+	return diags
+}
 
-	f, err := os.Open(sourceFile)
+// resourceGitopsSealSecretsCustomizeDiff plans an update when a file in source_dir was added,
+// removed or changed, or a seal setting changed, since the files were sealed
+func resourceGitopsSealSecretsCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	err := customizeSealingCertDiff(d, "kubeseal_cert")
 	if err != nil {
-		return "", err
+		return err
 	}
-	fReader := bufio.NewReader(f)
 
-	cmd := exec.Command(filepath.Join(binDir, "kubeseal"), args...)
+	if len(d.Id()) == 0 {
+		return nil
+	}
 
-	destFile := fmt.Sprintf("%s/%s", destDir, fileName)
+	for _, key := range []string{"source_dir", "kubeseal_cert", "annotations", "labels", "template_annotations", "template_labels", "rotation_trigger", "scope", "include", "exclude", "pass_through_non_secrets"} {
+		if !d.NewValueKnown(key) {
+			return setNewComputed(d, "file_hashes", "sealed_digest")
+		}
+	}
 
-	annotationArgs := []string{
-		"annotate",
-		"-f", "-",
-		"--local=true",
-		"--dry-run=client",
-		"--output=yaml"}
+	sealConfig := getSealSecretsConfig(d)
 
-	annotationArgs = append(annotationArgs, annotations...)
+	// a resource created before the digests were keyed gets a key, and new digests, on the next apply
+	if len(sealConfig.HashKey) == 0 {
+		return setNewComputed(d, "file_hash_key", "file_hashes", "sealed_digest")
+	}
 
-	cmd2 := exec.Command(filepath.Join(binDir, "kubectl"), annotationArgs...)
+	// the source files may be generated by another resource during the apply
+	if !fileExists(sealConfig.SourceDir) {
+		return setNewComputed(d, "file_hashes", "sealed_digest")
+	}
 
-	outfilePipeIn, err := os.Create(destFile)
+	fileDigests, err := sealConfig.fileDigests()
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer outfilePipeIn.Close()
-
-	sealedSecretPipeOut, sealedSecretPipeIn := io.Pipe()
 
-	cmd.Stdin = fReader
-	cmd.Stdout = sealedSecretPipeIn
-	cmd2.Stdin = sealedSecretPipeOut
-	cmd2.Stdout = outfilePipeIn
+	if reflect.DeepEqual(stringMapToInterfaces(fileDigests), d.Get("file_hashes").(map[string]interface{})) {
+		return nil
+	}
 
-	err = cmd.Start()
+	err = d.SetNew("file_hashes", fileDigests)
 	if err != nil {
-		return "", err
+		return err
 	}
-	err = cmd2.Start()
-	if err != nil {
-		return "", err
+
+	// the sealed output is only known once the files are sealed again
+	return d.SetNewComputed("sealed_digest")
+}
+
+// setNewComputed marks the attributes as known only after the apply
+func setNewComputed(d *schema.ResourceDiff, keys ...string) error {
+	for _, key := range keys {
+		err := d.SetNewComputed(key)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = func() error {
-		defer sealedSecretPipeIn.Close()
+	return nil
+}
 
-		return cmd.Wait()
-	}()
-	if err != nil {
-		return "", err
+// resourceGitopsSealSecretsV0 is the schema before annotations became a map, when each annotation
+// was a 'key=value' string in a list
+func resourceGitopsSealSecretsV0() *schema.Resource {
+	resourceSchema := resourceGitopsSealSecretsSchema()
+	resourceSchema["annotations"] = &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem:     &schema.Schema{Type: schema.TypeString},
 	}
 
-	err = cmd2.Wait()
+	return &schema.Resource{Schema: resourceSchema}
+}
+
+// resourceGitopsSealSecretsStateUpgradeV0 converts the 'key=value' annotations to a map
+func resourceGitopsSealSecretsStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, m interface{}) (map[string]interface{}, error) {
+	rawAnnotations, _ := rawState["annotations"].([]interface{})
+
+	annotations, err := parseAnnotations(interfacesToStrings(rawAnnotations))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return destFile, nil
+	rawState["annotations"] = stringMapToInterfaces(annotations)
+
+	return rawState, nil
 }
 
-func writeCertFile(ctx context.Context, dirName string, cert string) (string, error) {
+// encryptFile seals the secrets in the source file with the public key of the sealed-secrets
+// controller, the same way kubeseal does, and writes the SealedSecrets to the same relative path in
+// the dest dir
+func encryptFile(ctx context.Context, publicKey *rsa.PublicKey, sourceDir string, destDir string, fileName string, options SealOptions) (string, error) {
+	sourceFile := filepath.Join(sourceDir, fileName)
+	tflog.Debug(ctx, "Reading file contents: "+sourceFile)
 
-	err := os.MkdirAll(dirName, os.ModePerm)
+	data, err := os.ReadFile(sourceFile)
 	if err != nil {
 		return "", err
 	}
 
-	certFile := fmt.Sprintf("%s/kubeseal.crt", dirName)
+	sealedSecrets, err := sealSecretDocuments(data, isJsonFile(fileName), publicKey, options)
+	if err != nil {
+		return "", fmt.Errorf("failed to seal %s: %v", sourceFile, err)
+	}
 
-	tflog.Info(ctx, "Writing cert to file: "+certFile)
-	tflog.Debug(ctx, "cert: "+cert)
+	destFile := filepath.Join(destDir, fileName)
+	tflog.Debug(ctx, "Encrypted secret destination file: "+destFile)
 
-	err = os.WriteFile(certFile, []byte(cert), 0644)
+	err = os.MkdirAll(filepath.Dir(destFile), os.ModePerm)
 	if err != nil {
 		return "", err
 	}
 
-	return certFile, nil
+	err = os.WriteFile(destFile, sealedSecrets, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return destFile, nil
 }
@@ -0,0 +1,124 @@
+package gitops
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// unknownValue is the value the sdk uses for a value that is only known after the apply
+const unknownValue = "74D93920-ED26-11E3-AC10-0800200C9A66"
+
+func TestResourceGitopsSealSecretsRotationPublishesModule(t *testing.T) {
+	sourceDir := t.TempDir()
+	destDir := filepath.Join(t.TempDir(), "sealed-secrets")
+
+	err := os.WriteFile(filepath.Join(sourceDir, "db.yaml"), []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: db
+  namespace: my-namespace
+stringData:
+  password: db-password
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := &RecordingExecutor{}
+	providerConfig := testProviderConfig(executor)
+
+	sealResource := resourceGitopsSealSecrets()
+	sealConfig := map[string]interface{}{
+		"source_dir":    sourceDir,
+		"dest_dir":      destDir,
+		"kubeseal_cert": testSealingCert(t),
+	}
+
+	sealState, _ := applyTestConfig(t, sealResource, nil, sealConfig, providerConfig)
+
+	sealedDigest := sealState.Attributes["sealed_digest"]
+	if len(sealedDigest) == 0 {
+		t.Fatal("expected a sealed_digest")
+	}
+
+	sealedFile, err := os.ReadFile(filepath.Join(destDir, "db.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moduleResource := resourceGitopsModule()
+	moduleConfig := map[string]interface{}{
+		"name":            "db",
+		"namespace":       "my-namespace",
+		"layer":           "applications",
+		"content_dir":     destDir,
+		"content_trigger": sealedDigest,
+		"credentials":     testGitopsCredentials,
+		"config":          testGitopsConfig(t),
+	}
+
+	moduleState, _ := applyTestConfig(t, moduleResource, nil, moduleConfig, providerConfig)
+	contentDigest := moduleState.Attributes["content_digest"]
+
+	// rotating plans a new sealed_digest, which in turn plans an update of the module
+	sealConfig["rotation_trigger"] = "2"
+
+	sealDiff, err := sealResource.Diff(context.Background(), sealState, terraform.NewResourceConfigRaw(sealConfig), providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attribute := sealDiff.Attributes["sealed_digest"]; attribute == nil || !attribute.NewComputed {
+		t.Fatalf("expected the rotation to plan a new sealed_digest, got %v", sealDiff.Attributes)
+	}
+
+	moduleConfig["content_trigger"] = unknownValue
+
+	moduleDiff, err := moduleResource.Diff(context.Background(), moduleState, terraform.NewResourceConfigRaw(moduleConfig), providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moduleDiff == nil || moduleDiff.Attributes["content_digest"] == nil || !moduleDiff.Attributes["content_digest"].NewComputed {
+		t.Fatalf("expected the module to plan a new content_digest, got %v", moduleDiff)
+	}
+
+	// the apply re-seals the file and then publishes the re-sealed file with the module
+	sealState, _ = applyTestConfig(t, sealResource, sealState, sealConfig, providerConfig)
+
+	resealedFile, err := os.ReadFile(filepath.Join(destDir, "db.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resealedFile) == string(sealedFile) {
+		t.Error("expected the file to be sealed again")
+	}
+	if sealState.Attributes["sealed_digest"] == sealedDigest {
+		t.Error("expected a new sealed_digest")
+	}
+
+	moduleConfig["content_trigger"] = sealState.Attributes["sealed_digest"]
+
+	moduleState, _ = applyTestConfig(t, moduleResource, moduleState, moduleConfig, providerConfig)
+	if moduleState.Attributes["content_digest"] == contentDigest {
+		t.Error("expected a new content_digest")
+	}
+
+	requests := executor.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("expected the module to be published twice, got %d commands", len(requests))
+	}
+	if request := requests[1]; request.Args[0] != "gitops-module" || request.Args[1] != "db" {
+		t.Errorf("expected the module to be published again, got %v", request.Args)
+	}
+
+	// nothing changes without a new rotation
+	sealDiff, err = sealResource.Diff(context.Background(), sealState, terraform.NewResourceConfigRaw(sealConfig), providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sealDiff != nil && len(sealDiff.Attributes) > 0 {
+		t.Errorf("expected no changes, got %v", sealDiff.Attributes)
+	}
+}
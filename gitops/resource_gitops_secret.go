@@ -0,0 +1,307 @@
+package gitops
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"os"
+	"path"
+)
+
+func resourceGitopsSecret() *schema.Resource {
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsSecretCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsSecretRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsSecretUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsSecretDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsSecretImport,
+		},
+		CustomizeDiff: resourceGitopsSecretCustomizeDiff,
+		Timeouts:      gitopsResourceTimeouts(),
+		Schema: withSealingCertSchema(map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"namespace": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"server_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "default",
+				ForceNew: true,
+			},
+			"branch": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "main",
+				ForceNew: true,
+			},
+			"layer": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.StringInSlice([]string{"infrastructure", "services", "applications"}, false),
+			},
+			"type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "base",
+				ForceNew:     true,
+				ValidateFunc: validation.StringInSlice([]string{"base", "instances", "operators"}, false),
+			},
+			"credentials": {
+				Type:      schema.TypeString,
+				Required:  true,
+				Sensitive: true,
+			},
+			"config": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"kubeseal_cert": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The PEM encoded certificate of the sealed-secrets controller used to seal the secret",
+				ValidateFunc: validateSealingCert,
+			},
+			"scope": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      sealedSecretScopeStrict,
+				Description:  "The scope of the sealed secret: strict, namespace-wide or cluster-wide. A strict secret can only be unsealed with the same name and namespace, a namespace-wide secret can be renamed and a cluster-wide secret can be moved to any namespace.",
+				ValidateFunc: validation.StringInSlice(sealedSecretScopes, false),
+			},
+			"rotation_trigger": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "An arbitrary value that re-seals the secret and pushes it to the gitops repo when it changes, e.g. after the sealed-secrets controller rotated its key.",
+			},
+			"secret_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "The name of the secret that will be created. If not provided the module name will be used",
+			},
+			"secret_type": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "Opaque",
+				Description: "The type of the secret, e.g. Opaque or kubernetes.io/tls",
+			},
+			"data": {
+				Type:         schema.TypeMap,
+				Optional:     true,
+				Sensitive:    true,
+				Description:  "The base64 encoded values of the secret",
+				Elem:         &schema.Schema{Type: schema.TypeString},
+				ValidateFunc: validateBase64Values,
+			},
+			"string_data": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The plain text values of the secret. A key in both data and string_data takes the value from string_data.",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"labels": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The labels of the secret",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"annotations": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The annotations of the secret",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"tmp_dir": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "",
+			},
+		}, "kubeseal_cert"),
+	})
+}
+
+func validateBase64Values(value interface{}, key string) ([]string, []error) {
+	var errors []error
+
+	for name, item := range value.(map[string]interface{}) {
+		_, err := b64.StdEncoding.DecodeString(item.(string))
+		if err != nil {
+			errors = append(errors, fmt.Errorf("the value of %s in %s must be base64 encoded, use string_data for plain text values", name, key))
+		}
+	}
+
+	return nil, errors
+}
+
+func getGitopsSecretName(d *schema.ResourceData) string {
+	secretName := d.Get("secret_name").(string)
+	if len(secretName) > 0 {
+		return secretName
+	}
+
+	return getNameInput(d)
+}
+
+// registerSecretValues adds the values of the secret to the values masked by redact, both encoded
+// and in plain text
+func registerSecretValues(secret KubernetesSecret) {
+	for _, value := range secret.Data {
+		registerSecret(value)
+
+		decoded, err := b64.StdEncoding.DecodeString(value)
+		if err == nil {
+			registerSecret(string(decoded))
+		}
+	}
+
+	for _, value := range secret.StringData {
+		registerSecret(value)
+		registerSecret(b64.StdEncoding.EncodeToString([]byte(value)))
+	}
+}
+
+// resourceGitopsSecretCreate seals the secret in memory, so the plain text values are never written
+// to disk, and publishes the sealed secret to the gitops repo
+func resourceGitopsSecretCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	secretName := getGitopsSecretName(d)
+	workDir := sealedSecretWorkDir(d, "gitops_secret")
+	contentDir := sealedSecretContentDir(workDir)
+
+	publicKey, err := parseSealingKey(d.Get("kubeseal_cert").(string))
+	if err != nil {
+		return errorDiagnostics(err)
+	}
+
+	secret := KubernetesSecret{
+		ApiVersion: "v1",
+		Kind:       "Secret",
+		Metadata: SecretMetadata{
+			Name:        secretName,
+			Namespace:   getNamespaceInput(d),
+			Labels:      interfacesToStringMap(d.Get("labels").(map[string]interface{})),
+			Annotations: interfacesToStringMap(d.Get("annotations").(map[string]interface{})),
+		},
+		Type:       d.Get("secret_type").(string),
+		Data:       interfacesToStringMap(d.Get("data").(map[string]interface{})),
+		StringData: interfacesToStringMap(d.Get("string_data").(map[string]interface{})),
+	}
+
+	// the values are registered before any command runs so a failing command cannot echo them
+	registerSecretValues(secret)
+
+	sealedSecret, err := sealSecret(secret, publicKey, d.Get("scope").(string))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	sealedSecretYaml, err := marshalDocument(sealedSecret, false)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// clear the previous sealed secret, e.g. when the secret was renamed
+	err = os.RemoveAll(contentDir)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	err = os.MkdirAll(contentDir, os.ModePerm)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	sealedSecretFile := path.Join(contentDir, secretName+".yaml")
+	tflog.Debug(ctx, "Sealed secret written to: "+sealedSecretFile)
+
+	err = os.WriteFile(sealedSecretFile, sealedSecretYaml, 0644)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return publishSealedSecretModule(ctx, d, config, workDir)
+}
+
+func resourceGitopsSecretRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	return readGitopsModuleExists(ctx, d, sealedSecretModuleConfig(d, config, ""))
+}
+
+func resourceGitopsSecretUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	// any change to the contents of the secret, the cert or the rotation trigger re-seals the secret
+	// and pushes it through the module flow again
+	if d.HasChanges("kubeseal_cert", "rotation_trigger", "scope", "secret_name", "secret_type", "data", "string_data", "labels", "annotations") {
+		return resourceGitopsSecretCreate(ctx, d, m)
+	}
+
+	return resourceGitopsSecretRead(ctx, d, m)
+}
+
+// resourceGitopsSecretDelete removes the module from the gitops repo. The secret does not need to
+// be sealed again to remove it.
+func resourceGitopsSecretDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
+
+	return deleteSealedSecretModule(ctx, d, config, sealedSecretWorkDir(d, "gitops_secret"))
+}
+
+// resourceGitopsSecretCustomizeDiff plans the fingerprint and expiry of the kubeseal cert
+func resourceGitopsSecretCustomizeDiff(ctx context.Context, d *schema.ResourceDiff, m interface{}) error {
+	return customizeSealingCertDiff(d, "kubeseal_cert")
+}
+
+// resourceGitopsSecretImport adopts a secret that already exists in the gitops repo using the
+// namespace:name:serverName:layer:type import id. The values of the secret and kubeseal_cert are
+// not recoverable from the sealed secret and must be taken from the configuration.
+func resourceGitopsSecretImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	parts, err := parseImportId(d.Id(), "namespace:name:serverName:layer:type")
+	if err != nil {
+		return nil, err
+	}
+
+	moduleConfig := GitopsModuleConfig{
+		Namespace:  parts[0],
+		Name:       parts[1],
+		ServerName: parts[2],
+		Layer:      parts[3],
+		Type:       parts[4],
+	}
+
+	_, err = importGitopsModule(ctx, d, config, resourceGitopsSecret(), moduleConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setResourceValues(d, map[string]interface{}{
+		"namespace":   moduleConfig.Namespace,
+		"name":        moduleConfig.Name,
+		"server_name": moduleConfig.ServerName,
+		"layer":       moduleConfig.Layer,
+		"type":        moduleConfig.Type,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
+}
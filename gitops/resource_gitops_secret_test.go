@@ -0,0 +1,185 @@
+package gitops
+
+import (
+	"context"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// sealedSecretExecutor records the sealed secrets found in the content dir every time igc publishes
+// the module
+type sealedSecretExecutor struct {
+	*RecordingExecutor
+	published []map[string]SealedSecret
+}
+
+func newSealedSecretExecutor(t *testing.T) *sealedSecretExecutor {
+	executor := &sealedSecretExecutor{RecordingExecutor: &RecordingExecutor{}}
+
+	executor.Handler = func(ctx context.Context, request CommandRequest) error {
+		var contentDir string
+		for i, arg := range request.Args[:len(request.Args)-1] {
+			if arg == "--contentDir" {
+				contentDir = request.Args[i+1]
+			}
+		}
+
+		entries, err := os.ReadDir(contentDir)
+		if err != nil {
+			return err
+		}
+
+		sealedSecrets := map[string]SealedSecret{}
+		for _, entry := range entries {
+			data, err := os.ReadFile(filepath.Join(contentDir, entry.Name()))
+			if err != nil {
+				return err
+			}
+
+			if strings.Contains(string(data), "db-password") {
+				t.Errorf("%s holds the secret value in plain text", entry.Name())
+			}
+
+			sealedSecret := SealedSecret{}
+			err = yaml.Unmarshal(data, &sealedSecret)
+			if err != nil {
+				return err
+			}
+
+			sealedSecrets[entry.Name()] = sealedSecret
+		}
+
+		executor.published = append(executor.published, sealedSecrets)
+
+		return nil
+	}
+
+	return executor
+}
+
+func testGitopsSecretConfig(t *testing.T, tmpDir string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          "db-credentials",
+		"namespace":     "my-namespace",
+		"layer":         "infrastructure",
+		"credentials":   testGitopsCredentials,
+		"config":        testGitopsConfig(t),
+		"kubeseal_cert": testSealingCert(t),
+		"string_data":   map[string]interface{}{"password": "db-password"},
+		"labels":        map[string]interface{}{"app": "db"},
+		"tmp_dir":       tmpDir,
+	}
+}
+
+func TestResourceGitopsSecretCreate(t *testing.T) {
+	executor := newSealedSecretExecutor(t)
+	tmpDir := t.TempDir()
+
+	state, _ := applyTestConfig(t, resourceGitopsSecret(), nil, testGitopsSecretConfig(t, tmpDir), testProviderConfig(executor))
+
+	if state.ID != "my-namespace:db-credentials:default:infrastructure:base" {
+		t.Errorf("unexpected id %s", state.ID)
+	}
+
+	requests := executor.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("expected 1 command, got %d", len(requests))
+	}
+
+	expectedArgs := []string{
+		"gitops-module", "db-credentials",
+		"-n", "my-namespace",
+		"--branch", "main",
+		"--serverName", "default",
+		"--layer", "infrastructure",
+		"--type", "base",
+		"--contentDir", filepath.Join(tmpDir, "db-credentials", "sealed-secrets"),
+		"--caCert", "/certs/ca.crt",
+		"--debug", "false",
+	}
+	if !reflect.DeepEqual(requests[0].Args, expectedArgs) {
+		t.Errorf("unexpected args\nexpected: %v\nactual:   %v", expectedArgs, requests[0].Args)
+	}
+	assertEnv(t, requests[0], "GIT_CREDENTIALS", testGitopsCredentials)
+
+	sealedSecret, found := executor.published[0]["db-credentials.yaml"]
+	if !found || len(executor.published[0]) != 1 {
+		t.Fatalf("expected db-credentials.yaml to be published, got %v", executor.published[0])
+	}
+	if sealedSecret.Metadata.Name != "db-credentials" || sealedSecret.Metadata.Namespace != "my-namespace" || sealedSecret.Spec.Template.Type != "Opaque" {
+		t.Errorf("unexpected sealed secret %+v", sealedSecret)
+	}
+	if sealedSecret.Spec.Template.Metadata.Labels["app"] != "db" {
+		t.Errorf("expected the labels on the secret template, got %+v", sealedSecret.Spec.Template.Metadata)
+	}
+	if _, found := sealedSecret.Spec.EncryptedData["password"]; !found {
+		t.Error("expected password in the encrypted data")
+	}
+}
+
+func TestResourceGitopsSecretUpdateReseals(t *testing.T) {
+	executor := newSealedSecretExecutor(t)
+	providerConfig := testProviderConfig(executor)
+	resource := resourceGitopsSecret()
+
+	raw := testGitopsSecretConfig(t, t.TempDir())
+	state, _ := applyTestConfig(t, resource, nil, raw, providerConfig)
+
+	raw["string_data"] = map[string]interface{}{"password": "new-db-password", "username": "admin"}
+	state, diff := applyTestConfig(t, resource, state, raw, providerConfig)
+
+	if diff.RequiresNew() {
+		t.Error("expected the secret to be updated in place")
+	}
+	if len(executor.published) != 2 {
+		t.Fatalf("expected the secret to be published again, got %d commands", len(executor.published))
+	}
+
+	encryptedData := executor.published[1]["db-credentials.yaml"].Spec.EncryptedData
+	if len(encryptedData) != 2 || len(encryptedData["username"]) == 0 {
+		t.Errorf("expected the new values to be sealed, got %v", encryptedData)
+	}
+	if encryptedData["password"] == executor.published[0]["db-credentials.yaml"].Spec.EncryptedData["password"] {
+		t.Error("expected the changed password to be sealed again")
+	}
+	if state.ID != "my-namespace:db-credentials:default:infrastructure:base" {
+		t.Errorf("unexpected id %s", state.ID)
+	}
+}
+
+func TestResourceGitopsSecretDelete(t *testing.T) {
+	executor := newSealedSecretExecutor(t)
+	providerConfig := testProviderConfig(executor)
+	resource := resourceGitopsSecret()
+	tmpDir := t.TempDir()
+
+	state, _ := applyTestConfig(t, resource, nil, testGitopsSecretConfig(t, tmpDir), providerConfig)
+
+	// the values and the cert are not needed to remove the secret
+	state.Attributes["kubeseal_cert"] = ""
+	delete(state.Attributes, "string_data.password")
+	state.Attributes["string_data.%"] = "0"
+
+	diags := resourceGitopsSecretDelete(context.Background(), resource.Data(state), providerConfig)
+	assertNoErrors(t, diags)
+
+	requests := executor.Requests()
+	if len(requests) != 2 || requests[1].Args[0] != "gitops-module" || !strings.Contains(strings.Join(requests[1].Args, " "), "--delete") {
+		t.Fatalf("expected the module to be deleted, got %v", requests)
+	}
+	// sealing is randomized, so a sealed secret left from create would differ if it had been re-sealed
+	for name, sealedSecret := range executor.published[1] {
+		if !reflect.DeepEqual(sealedSecret, executor.published[0][name]) {
+			t.Errorf("expected %s not to be sealed again on delete", name)
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(tmpDir, "db-credentials"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected the work dir to be removed, got %v", err)
+	}
+}
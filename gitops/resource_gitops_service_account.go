@@ -8,38 +8,48 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 	"gopkg.in/yaml.v3"
 	"os"
+	"strings"
 )
 
 func resourceGitopsServiceAccount() *schema.Resource {
-	return &schema.Resource{
-		CreateContext: resourceGitopsServiceAccountCreate,
-		ReadContext:   resourceGitopsServiceAccountRead,
-		UpdateContext: resourceGitopsServiceAccountUpdate,
-		DeleteContext: resourceGitopsServiceAccountDelete,
+	return withResourceDiagnostics(&schema.Resource{
+		CreateWithoutTimeout: withGitopsTimeout(schema.TimeoutCreate, resourceGitopsServiceAccountCreate),
+		ReadWithoutTimeout:   withGitopsTimeout(schema.TimeoutRead, resourceGitopsServiceAccountRead),
+		UpdateWithoutTimeout: withGitopsTimeout(schema.TimeoutUpdate, resourceGitopsServiceAccountUpdate),
+		DeleteWithoutTimeout: withGitopsTimeout(schema.TimeoutDelete, resourceGitopsServiceAccountDelete),
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceGitopsServiceAccountImport,
+		},
+		Timeouts: gitopsResourceTimeouts(),
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"namespace": {
 				Type:     schema.TypeString,
 				Required: true,
+				ForceNew: true,
 			},
 			"server_name": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "default",
+				ForceNew: true,
 			},
 			"branch": {
 				Type:     schema.TypeString,
 				Optional: true,
 				Default:  "main",
+				ForceNew: true,
 			},
 			"layer": {
 				Type:         schema.TypeString,
 				Optional:     true,
 				ValidateFunc: validation.StringInSlice([]string{"infrastructure", "services", "applications"}, false),
 				Default:      "infrastructure",
+				ForceNew:     true,
 			},
 			"credentials": {
 				Type:      schema.TypeString,
@@ -145,7 +155,7 @@ func resourceGitopsServiceAccount() *schema.Resource {
 				Elem:        &schema.Schema{Type: schema.TypeString},
 			},
 		},
-	}
+	})
 }
 
 type RBACRule struct {
@@ -179,7 +189,7 @@ func resourceGitopsServiceAccountCreate(ctx context.Context, d *schema.ResourceD
 
 	name := getNameInput(d)
 	namespace := getNamespaceInput(d)
-	layer := "infrastructure"
+	layer := getLayerInput(d)
 	moduleType := "base"
 
 	tmpDir := d.Get("tmp_dir").(string)
@@ -254,9 +264,9 @@ func resourceGitopsServiceAccountCreate(ctx context.Context, d *schema.ResourceD
 		IgnoreDiff: "[{\"jsonPointers\": [\"imagePullSecrets\", \"secrets\"], \"kind\": \"ServiceAccount\"}]",
 	}
 
-	id, err := populateGitopsModule(ctx, config.BinDir, moduleConfig, false)
+	id, err := populateGitopsModule(ctx, config, moduleConfig, false)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
@@ -264,16 +274,72 @@ func resourceGitopsServiceAccountCreate(ctx context.Context, d *schema.ResourceD
 	return diags
 }
 
-func resourceGitopsServiceAccountRead(_ context.Context, _ *schema.ResourceData, _ interface{}) diag.Diagnostics {
-	// Warning or errors can be collected in a slice type
-	var diags diag.Diagnostics
+func resourceGitopsServiceAccountRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	config := m.(*ProviderConfig)
 
-	return diags
+	moduleConfig := GitopsModuleConfig{
+		Name:        getNameInput(d) + "-sa",
+		Namespace:   getNamespaceInput(d),
+		Branch:      getBranchInput(d),
+		ServerName:  getServerNameInput(d),
+		Layer:       getLayerInput(d),
+		Type:        "base",
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
+		Credentials: getCredentialsInput(d),
+		Config:      getGitopsConfigInput(d),
+	}
+
+	return readGitopsModuleExists(ctx, d, moduleConfig)
+}
+
+// resourceGitopsServiceAccountImport adopts a service account that already exists in the gitops repo.
+// The import id is the resource id, namespace:name-sa:serverName:layer:base. The rbac settings are
+// not read back from the payload and must be taken from the configuration.
+func resourceGitopsServiceAccountImport(ctx context.Context, d *schema.ResourceData, m interface{}) ([]*schema.ResourceData, error) {
+	config := m.(*ProviderConfig)
+
+	parts, err := parseImportId(d.Id(), "namespace:name:serverName:layer:type")
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(parts[1], "-sa") {
+		return nil, fmt.Errorf("name in import id (%s) must end with -sa", d.Id())
+	}
+	if parts[4] != "base" {
+		return nil, fmt.Errorf("service accounts have the base type, import id (%s)", d.Id())
+	}
+
+	moduleConfig := GitopsModuleConfig{
+		Namespace:  parts[0],
+		Name:       parts[1],
+		ServerName: parts[2],
+		Layer:      parts[3],
+		Type:       parts[4],
+	}
+
+	_, err = importGitopsModule(ctx, d, config, resourceGitopsServiceAccount(), moduleConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setResourceValues(d, map[string]interface{}{
+		"namespace":   moduleConfig.Namespace,
+		"name":        strings.TrimSuffix(moduleConfig.Name, "-sa"),
+		"server_name": moduleConfig.ServerName,
+		"layer":       moduleConfig.Layer,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*schema.ResourceData{d}, nil
 }
 
 func resourceGitopsServiceAccountUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// TODO implement update...
-	return resourceGitopsModuleRead(ctx, d, m)
+	return resourceGitopsServiceAccountRead(ctx, d, m)
 }
 
 func resourceGitopsServiceAccountDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -284,7 +350,7 @@ func resourceGitopsServiceAccountDelete(ctx context.Context, d *schema.ResourceD
 
 	name := getNameInput(d)
 	namespace := getNamespaceInput(d)
-	layer := "infrastructure"
+	layer := getLayerInput(d)
 	moduleType := "base"
 
 	name = name + "-sa"
@@ -308,9 +374,9 @@ func resourceGitopsServiceAccountDelete(ctx context.Context, d *schema.ResourceD
 		},
 	}
 
-	id, err := populateGitopsModule(ctx, config.BinDir, moduleConfig, true)
+	id, err := populateGitopsModule(ctx, config, moduleConfig, true)
 	if err != nil {
-		return diag.FromErr(err)
+		return errorDiagnostics(err)
 	}
 
 	d.SetId(id)
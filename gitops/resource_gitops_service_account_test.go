@@ -0,0 +1,53 @@
+package gitops
+
+import (
+	"context"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"testing"
+)
+
+func TestResourceGitopsServiceAccountLayer(t *testing.T) {
+	_, providerConfig := testHttpsGitRepo(t)
+	resource := resourceGitopsServiceAccount()
+
+	gitopsConfig, credentials, err := importLookupConfig(providerConfig, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw := map[string]interface{}{
+		"name":        "my-module",
+		"namespace":   "my-namespace",
+		"layer":       "services",
+		"config":      gitopsConfig,
+		"credentials": credentials,
+		"tmp_dir":     t.TempDir(),
+	}
+
+	state, _ := applyTestConfig(t, resource, nil, raw, providerConfig)
+	if state.ID != "my-namespace:my-module-sa:default:services:base" {
+		t.Fatalf("expected the service account in the services layer, got %s", state.ID)
+	}
+
+	// the service account is refreshed in the services layer
+	refreshed, diags := resource.RefreshWithoutUpgrade(context.Background(), state, providerConfig)
+	assertNoErrors(t, diags)
+	if refreshed == nil || refreshed.ID != state.ID {
+		t.Fatalf("expected the service account to be found in the services layer, got %v", refreshed)
+	}
+
+	d := resource.Data(&terraform.InstanceState{ID: state.ID})
+	imported, err := resource.Importer.StateContext(context.Background(), d, providerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer := imported[0].Get("layer"); layer != "services" {
+		t.Errorf("expected the layer to be taken from the import id, got %v", layer)
+	}
+
+	d = resource.Data(&terraform.InstanceState{ID: "my-namespace:my-module-sa:default:services:instances"})
+	_, err = resource.Importer.StateContext(context.Background(), d, providerConfig)
+	if err == nil {
+		t.Error("expected an import id with a type other than base to be rejected")
+	}
+}
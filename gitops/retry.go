@@ -0,0 +1,186 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const retryBaseWait = time.Second
+
+// RetryConfig controls how often transient failures of the git operations are retried
+type RetryConfig struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// MaxWait caps the wait between two attempts
+	MaxWait time.Duration
+}
+
+// transientErrors maps the reason of a transient failure to the messages that identify it in the
+// error or the stderr of the command. The messages are matched in lower case.
+var transientErrors = []struct {
+	reason   string
+	messages []string
+}{
+	{
+		reason: "push rejected",
+		messages: []string{
+			"non-fast-forward",
+			"[rejected]",
+			"updates were rejected",
+			"failed to push some refs",
+			"fetch first",
+			"cannot lock ref",
+		},
+	},
+	{
+		reason: "rate limited",
+		messages: []string{
+			"rate limit",
+			"too many requests",
+			"returned error: 429",
+			"status code: 429",
+		},
+	},
+	{
+		reason: "network error",
+		messages: []string{
+			"connection reset",
+			"econnreset",
+			"etimedout",
+			"socket hang up",
+			"unexpected eof",
+			"tls handshake timeout",
+			"returned error: 502",
+			"returned error: 503",
+			"returned error: 504",
+			"status code: 502",
+			"status code: 503",
+			"status code: 504",
+		},
+	},
+}
+
+// transientErrorReason classifies the error. An empty reason means the error is not transient and
+// should not be retried.
+func transientErrorReason(err error) string {
+	messages := []string{strings.ToLower(err.Error())}
+
+	var commandError *CommandError
+	if errors.As(err, &commandError) {
+		for _, line := range commandError.Stderr {
+			messages = append(messages, strings.ToLower(line))
+		}
+	}
+
+	for _, transientError := range transientErrors {
+		for _, match := range transientError.messages {
+			for _, message := range messages {
+				if strings.Contains(message, match) {
+					return transientError.reason
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// retryWait returns the exponential backoff with jitter before the given attempt
+func retryWait(attempt int, maxWait time.Duration) time.Duration {
+	wait := retryBaseWait << (attempt - 1)
+	if wait <= 0 || wait > maxWait {
+		wait = maxWait
+	}
+
+	if wait/2 <= 0 {
+		return wait
+	}
+
+	// use a random wait between half and all of the backoff so concurrent applies do not retry in lockstep
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)))
+}
+
+// retryTransient runs the operation until it succeeds, fails with an error that is not transient,
+// the attempts are exhausted or the context ends
+func retryTransient(ctx context.Context, config RetryConfig, description string, operation func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= config.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		reason := transientErrorReason(err)
+		if len(reason) == 0 {
+			return err
+		}
+
+		wait := retryWait(attempt, config.MaxWait)
+
+		tflog.Warn(ctx, fmt.Sprintf("%s failed (%s), retrying in %s (attempt %d of %d): %v", description, reason, wait, attempt+1, config.MaxAttempts, err))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// retryableCommands are the commands that can safely run again after a transient failure: the
+// commands that write the same module, namespace or metadata to the gitops repo on every run and
+// the commands that only read it. igc gitops-init is not retried since it creates the repo.
+var retryableCommands = map[string]bool{
+	"igc gitops-module":          true,
+	"igc gitops-namespace":       true,
+	"igc gitops-metadata-update": true,
+	"igc gitops-config":          true,
+	"igc gitops-metadata-get":    true,
+}
+
+type retryExecutor struct {
+	executor Executor
+	config   RetryConfig
+}
+
+// NewRetryExecutor wraps the executor so the retryable commands failing with a transient git error
+// (rejected push, rate limit, network reset) are retried with exponential backoff. Other commands
+// and commands reading from Stdin, whose input cannot be replayed, are run once.
+func NewRetryExecutor(executor Executor, config RetryConfig) Executor {
+	return &retryExecutor{executor: executor, config: config}
+}
+
+// Execute gives every attempt its own stdout buffer so only the output of the successful attempt
+// reaches the caller
+func (e *retryExecutor) Execute(ctx context.Context, request CommandRequest) error {
+	if request.Stdin != nil || !retryableCommands[request.command()] {
+		return e.executor.Execute(ctx, request)
+	}
+
+	stdout := request.Stdout
+
+	var output *bytes.Buffer
+	err := retryTransient(ctx, e.config, request.command(), func() error {
+		attempt := request
+		if stdout != nil {
+			output = &bytes.Buffer{}
+			attempt.Stdout = output
+		}
+
+		return e.executor.Execute(ctx, attempt)
+	})
+	if err != nil || stdout == nil {
+		return err
+	}
+
+	_, err = output.WriteTo(stdout)
+
+	return err
+}
@@ -0,0 +1,173 @@
+package gitops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransientErrorReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{name: "rejected push", err: errors.New("unable to push branch main: non-fast-forward update"), expected: "push rejected"},
+		{name: "rejected push in stderr", err: &CommandError{Command: "igc gitops-module", Err: errors.New("exit status 1"), Stderr: []string{"! [rejected]        main -> main (fetch first)"}}, expected: "push rejected"},
+		{name: "rate limit", err: errors.New("API rate limit exceeded for user"), expected: "rate limited"},
+		{name: "too many requests in stderr", err: &CommandError{Err: errors.New("exit status 1"), Stderr: []string{"HTTP 429 Too Many Requests"}}, expected: "rate limited"},
+		{name: "connection reset", err: errors.New("read tcp: connection reset by peer"), expected: "network error"},
+		{name: "bad gateway", err: errors.New("unexpected client error: unexpected requesting https://github.com/org/gitops status code: 502"), expected: "network error"},
+		{name: "wrapped error", err: fmt.Errorf("gitops-module: %w", errors.New("ETIMEDOUT")), expected: "network error"},
+		{name: "authentication", err: errors.New("authentication required")},
+		{name: "missing branch", err: &CommandError{Err: errors.New("exit status 128"), Stderr: []string{"fatal: couldn't find remote ref main"}}},
+		{name: "invalid config", err: errors.New("unable to parse gitops config")},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if reason := transientErrorReason(test.err); reason != test.expected {
+				t.Errorf("expected reason %q, got %q", test.expected, reason)
+			}
+		})
+	}
+}
+
+func TestRetryWait(t *testing.T) {
+	maxWait := 5 * time.Second
+
+	for attempt := 1; attempt <= 70; attempt++ {
+		backoff := maxWait
+		if attempt <= 3 {
+			backoff = retryBaseWait << (attempt - 1)
+		}
+
+		for i := 0; i < 20; i++ {
+			wait := retryWait(attempt, maxWait)
+			if wait < backoff/2 || wait > backoff {
+				t.Fatalf("attempt %d: expected a wait between %s and %s, got %s", attempt, backoff/2, backoff, wait)
+			}
+		}
+	}
+
+	if wait := retryWait(1, 0); wait != 0 {
+		t.Errorf("expected no wait with retry_max_wait = 0, got %s", wait)
+	}
+}
+
+// flakyExecutor fails the first failures attempts with err, writing the attempt number to stdout
+func flakyExecutor(failures int, err error) *RecordingExecutor {
+	attempt := 0
+
+	return &RecordingExecutor{
+		Handler: func(ctx context.Context, request CommandRequest) error {
+			attempt++
+
+			if request.Stdout != nil {
+				_, _ = fmt.Fprintf(request.Stdout, "attempt %d", attempt)
+			}
+
+			if attempt <= failures {
+				return err
+			}
+
+			return nil
+		},
+	}
+}
+
+func TestRetryExecutorReturnsOutputOfSuccessfulAttempt(t *testing.T) {
+	executor := flakyExecutor(2, errors.New("connection reset by peer"))
+
+	var stdout bytes.Buffer
+	err := NewRetryExecutor(executor, RetryConfig{MaxAttempts: 5, MaxWait: time.Millisecond}).Execute(context.Background(), CommandRequest{
+		Name:   "igc",
+		Args:   []string{"gitops-config", "https://github.com/org/gitops"},
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(executor.Requests()) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(executor.Requests()))
+	}
+	if stdout.String() != "attempt 3" {
+		t.Errorf("expected only the output of the successful attempt, got %q", stdout.String())
+	}
+}
+
+func TestRetryExecutorStopsAtMaxAttempts(t *testing.T) {
+	executor := flakyExecutor(10, errors.New("rate limit exceeded"))
+
+	err := NewRetryExecutor(executor, RetryConfig{MaxAttempts: 3, MaxWait: time.Millisecond}).Execute(context.Background(), CommandRequest{
+		Name: "igc",
+		Args: []string{"gitops-module", "my-module"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Errorf("expected the last error, got %v", err)
+	}
+	if len(executor.Requests()) != 3 {
+		t.Errorf("expected 3 attempts, got %d", len(executor.Requests()))
+	}
+}
+
+func TestRetryExecutorRunsOnce(t *testing.T) {
+	tests := []struct {
+		name    string
+		request CommandRequest
+		err     error
+		// buffered is set when the output goes through the retry buffer and is dropped on failure
+		buffered bool
+	}{
+		{name: "not retryable", request: CommandRequest{Name: "igc", Args: []string{"gitops-init", "gitops"}}, err: errors.New("connection reset by peer")},
+		{name: "other cli", request: CommandRequest{Name: "kubectl", Args: []string{"apply"}}, err: errors.New("connection reset by peer")},
+		{name: "stdin", request: CommandRequest{Name: "igc", Args: []string{"gitops-module", "my-module"}, Stdin: strings.NewReader("input")}, err: errors.New("connection reset by peer")},
+		{name: "permanent error", request: CommandRequest{Name: "igc", Args: []string{"gitops-module", "my-module"}}, err: errors.New("authentication required"), buffered: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			executor := flakyExecutor(1, test.err)
+
+			var stdout bytes.Buffer
+			test.request.Stdout = &stdout
+
+			err := NewRetryExecutor(executor, RetryConfig{MaxAttempts: 5, MaxWait: time.Millisecond}).Execute(context.Background(), test.request)
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v, got %v", test.err, err)
+			}
+
+			requests := executor.Requests()
+			if len(requests) != 1 {
+				t.Errorf("expected 1 attempt, got %d", len(requests))
+			}
+			if test.request.Stdin != nil && requests[0].Stdin != test.request.Stdin {
+				t.Error("expected the stdin to be passed through")
+			}
+			if !test.buffered && stdout.String() != "attempt 1" {
+				t.Errorf("expected the output to be written directly, got %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestRetryTransientStopsWhenContextEnds(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	attempts := 0
+	err := retryTransient(ctx, RetryConfig{MaxAttempts: 5, MaxWait: time.Hour}, "gitops-module", func() error {
+		attempts++
+		cancel()
+
+		return io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, io.ErrUnexpectedEOF) || attempts != 1 {
+		t.Errorf("expected a single attempt, got %d attempts and %v", attempts, err)
+	}
+}
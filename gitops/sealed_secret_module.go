@@ -0,0 +1,100 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"os"
+	"path"
+)
+
+// sealedSecretWorkDir returns the dir, under tmp_dir, where a gitops_secret or gitops_pull_secret is
+// sealed. Without tmp_dir the resource gets its own dir under .tmp/<kind>/<namespace>/<name>.
+func sealedSecretWorkDir(d *schema.ResourceData, kind string) string {
+	name := getNameInput(d)
+
+	tmpDir := d.Get("tmp_dir").(string)
+	if len(tmpDir) == 0 {
+		tmpDir = fmt.Sprintf(".tmp/%s/%s/%s", kind, getNamespaceInput(d), name)
+	}
+
+	return path.Join(tmpDir, name)
+}
+
+// sealedSecretContentDir returns the dir of the work dir holding the sealed secrets published to the
+// gitops repo
+func sealedSecretContentDir(workDir string) string {
+	return path.Join(workDir, "sealed-secrets")
+}
+
+// sealedSecretModuleConfig describes the module that publishes the sealed secrets in contentDir
+func sealedSecretModuleConfig(d *schema.ResourceData, config *ProviderConfig, contentDir string) GitopsModuleConfig {
+	return GitopsModuleConfig{
+		Name:        getNameInput(d),
+		Namespace:   getNamespaceInput(d),
+		Branch:      getBranchInput(d),
+		ServerName:  getServerNameInput(d),
+		Layer:       getLayerInput(d),
+		Type:        getTypeInput(d),
+		ContentDir:  contentDir,
+		CaCert:      config.GitConfig.CaCertFile,
+		Debug:       config.Debug,
+		Credentials: getCredentialsInput(d),
+		Config:      getGitopsConfigInput(d),
+	}
+}
+
+// publishSealedSecretModule pushes the sealed secrets in the work dir to the gitops repo and records
+// the fingerprint and expiry of the kubeseal cert they were sealed with
+func publishSealedSecretModule(ctx context.Context, d *schema.ResourceData, config *ProviderConfig, workDir string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	id, err := populateGitopsModule(ctx, config, sealedSecretModuleConfig(d, config, sealedSecretContentDir(workDir)), false)
+	if err != nil {
+		return errorDiagnostics(err)
+	}
+
+	d.SetId(id)
+
+	err = setSealingCertValues(d, "kubeseal_cert")
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return diags
+}
+
+// deleteSealedSecretModule removes the module from the gitops repo using the identity in the state
+// and removes the work dir. The secret is not sealed again, so neither its values nor a valid
+// kubeseal_cert are needed.
+func deleteSealedSecretModule(ctx context.Context, d *schema.ResourceData, config *ProviderConfig, workDir string) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	contentDir := sealedSecretContentDir(workDir)
+
+	err := os.MkdirAll(contentDir, os.ModePerm)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	_, err = populateGitopsModule(ctx, config, sealedSecretModuleConfig(d, config, contentDir), true)
+	if err != nil {
+		return errorDiagnostics(err)
+	}
+
+	// the work dir can still hold unsealed secrets written by earlier versions
+	err = secureRemoveAll(workDir)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if len(d.Get("tmp_dir").(string)) == 0 {
+		// the default tmp dir belongs to the resource, it is removed once it is empty
+		_ = os.Remove(path.Dir(workDir))
+	}
+
+	d.SetId("")
+
+	return diags
+}
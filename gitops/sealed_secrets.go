@@ -0,0 +1,439 @@
+package gitops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+)
+
+const (
+	sealedSecretApiVersion = "bitnami.com/v1alpha1"
+	sealedSecretKind       = "SealedSecret"
+
+	// sessionKeyBytes is the size of the AES-256 key generated for every sealed value
+	sessionKeyBytes = 32
+
+	namespaceWideAnnotation = "sealedsecrets.bitnami.com/namespace-wide"
+	clusterWideAnnotation   = "sealedsecrets.bitnami.com/cluster-wide"
+
+	// lastAppliedAnnotation is dropped from the template, like kubeseal does, because it holds a
+	// copy of the plaintext secret
+	lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+	// defaultSecretNamespace is the namespace kubeseal applies when the secret has none and there is
+	// no kubeconfig context
+	defaultSecretNamespace = "default"
+)
+
+const (
+	sealedSecretScopeStrict        = "strict"
+	sealedSecretScopeNamespaceWide = "namespace-wide"
+	sealedSecretScopeClusterWide   = "cluster-wide"
+)
+
+// SealedSecretMetadata keeps its fields in alphabetical order so the yaml matches the output of kubeseal
+type SealedSecretMetadata struct {
+	Annotations       map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	CreationTimestamp *string           `json:"creationTimestamp" yaml:"creationTimestamp"`
+	Labels            map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Name              string            `json:"name" yaml:"name"`
+	Namespace         string            `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+}
+
+type SealedSecretTemplate struct {
+	Metadata SealedSecretMetadata `json:"metadata" yaml:"metadata"`
+	Type     string               `json:"type,omitempty" yaml:"type,omitempty"`
+}
+
+type SealedSecretSpec struct {
+	EncryptedData map[string]string    `json:"encryptedData" yaml:"encryptedData"`
+	Template      SealedSecretTemplate `json:"template" yaml:"template"`
+}
+
+type SealedSecret struct {
+	ApiVersion string               `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string               `json:"kind" yaml:"kind"`
+	Metadata   SealedSecretMetadata `json:"metadata" yaml:"metadata"`
+	Spec       SealedSecretSpec     `json:"spec" yaml:"spec"`
+}
+
+var sealedSecretScopes = []string{sealedSecretScopeStrict, sealedSecretScopeNamespaceWide, sealedSecretScopeClusterWide}
+
+// sealedSecretScope returns the scope requested by the annotations of the secret
+func sealedSecretScope(annotations map[string]string) string {
+	if annotations[clusterWideAnnotation] == "true" {
+		return sealedSecretScopeClusterWide
+	}
+
+	if annotations[namespaceWideAnnotation] == "true" {
+		return sealedSecretScopeNamespaceWide
+	}
+
+	return sealedSecretScopeStrict
+}
+
+// sealedSecretLabel returns the label the value is encrypted with. The controller only decrypts the
+// value for a secret whose name and namespace produce the same label.
+func sealedSecretLabel(namespace string, name string, scope string) []byte {
+	switch scope {
+	case sealedSecretScopeClusterWide:
+		return nil
+	case sealedSecretScopeNamespaceWide:
+		return []byte(namespace)
+	}
+
+	return []byte(fmt.Sprintf("%s/%s", namespace, name))
+}
+
+// hybridEncrypt implements the sealing scheme of the sealed-secrets controller: the value is
+// encrypted with a random AES-GCM session key and the session key is encrypted with RSA-OAEP. The
+// result is the length of the RSA ciphertext as two big endian bytes, the RSA ciphertext and the
+// AES ciphertext.
+func hybridEncrypt(random io.Reader, publicKey *rsa.PublicKey, plaintext []byte, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(random, sessionKey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), random, publicKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2, 2+len(rsaCiphertext)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+
+	// the session key is only used once so a zero nonce is safe
+	zeroNonce := make([]byte, aead.NonceSize())
+
+	return aead.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
+
+// values returns the decoded data of the secret merged with the stringData, which takes
+// precedence like it does when the secret is applied to the cluster
+func (s KubernetesSecret) values() (map[string][]byte, error) {
+	result := map[string][]byte{}
+
+	for key, value := range s.Data {
+		decoded, err := b64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("the value of %s in secret %s is not base64 encoded: %v", key, s.Metadata.Name, err)
+		}
+
+		result[key] = decoded
+	}
+
+	for key, value := range s.StringData {
+		result[key] = []byte(value)
+	}
+
+	return result, nil
+}
+
+// withScopeAnnotations replaces the scope annotations with the ones of the scope, like kubeseal
+// does with the --scope flag
+func withScopeAnnotations(annotations map[string]string, scope string) map[string]string {
+	result := copyStringMap(annotations)
+	if result == nil {
+		result = map[string]string{}
+	}
+
+	delete(result, namespaceWideAnnotation)
+	delete(result, clusterWideAnnotation)
+
+	for key, value := range scopeAnnotations(scope) {
+		result[key] = value
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+
+	return result
+}
+
+// sealSecret encrypts the values of the secret with the public key and builds the SealedSecret
+// resource that the controller turns back into the secret. When the scope is empty the scope
+// requested by the annotations of the secret is used.
+func sealSecret(secret KubernetesSecret, publicKey *rsa.PublicKey, scope string) (*SealedSecret, error) {
+	if secret.Kind != "Secret" {
+		return nil, fmt.Errorf("expected a Secret but found kind %q", secret.Kind)
+	}
+
+	if len(secret.Metadata.Name) == 0 {
+		return nil, errors.New("the secret has no name")
+	}
+
+	namespace := secret.Metadata.Namespace
+	if len(namespace) == 0 {
+		namespace = defaultSecretNamespace
+	}
+
+	if len(scope) > 0 {
+		secret.Metadata.Annotations = withScopeAnnotations(secret.Metadata.Annotations, scope)
+	}
+
+	scope = sealedSecretScope(secret.Metadata.Annotations)
+	label := sealedSecretLabel(namespace, secret.Metadata.Name, scope)
+
+	values, err := secret.values()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedData := map[string]string{}
+	for key, value := range values {
+		ciphertext, err := hybridEncrypt(rand.Reader, publicKey, value, label)
+		if err != nil {
+			return nil, err
+		}
+
+		encryptedData[key] = b64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	templateAnnotations := copyStringMap(secret.Metadata.Annotations)
+	delete(templateAnnotations, lastAppliedAnnotation)
+
+	sealedSecret := &SealedSecret{
+		ApiVersion: sealedSecretApiVersion,
+		Kind:       sealedSecretKind,
+		Metadata: SealedSecretMetadata{
+			Annotations: scopeAnnotations(scope),
+			Name:        secret.Metadata.Name,
+			Namespace:   namespace,
+		},
+		Spec: SealedSecretSpec{
+			EncryptedData: encryptedData,
+			Template: SealedSecretTemplate{
+				Metadata: SealedSecretMetadata{
+					Annotations: templateAnnotations,
+					Labels:      copyStringMap(secret.Metadata.Labels),
+					Name:        secret.Metadata.Name,
+					Namespace:   namespace,
+				},
+				Type: secret.Type,
+			},
+		},
+	}
+
+	return sealedSecret, nil
+}
+
+// scopeAnnotations returns the annotations that tell the controller the scope of the sealed secret
+func scopeAnnotations(scope string) map[string]string {
+	switch scope {
+	case sealedSecretScopeClusterWide:
+		return map[string]string{clusterWideAnnotation: "true"}
+	case sealedSecretScopeNamespaceWide:
+		return map[string]string{namespaceWideAnnotation: "true"}
+	}
+
+	return nil
+}
+
+// parseAnnotations converts the list of 'key=value' annotations into a map
+func parseAnnotations(annotations []string) (map[string]string, error) {
+	result := map[string]string{}
+
+	for _, annotation := range annotations {
+		key, value, found := strings.Cut(annotation, "=")
+		if !found || len(key) == 0 {
+			return nil, fmt.Errorf("invalid annotation %q, expected the format key=value", annotation)
+		}
+
+		result[key] = value
+	}
+
+	return result, nil
+}
+
+// addMetadata adds the annotations and labels to the metadata of the sealed secret and the
+// template annotations and labels to the metadata of the secret it is unsealed into
+func (s *SealedSecret) addMetadata(options SealOptions) {
+	s.Metadata.Annotations = mergeStringMaps(s.Metadata.Annotations, options.Annotations)
+	s.Metadata.Labels = mergeStringMaps(s.Metadata.Labels, options.Labels)
+
+	template := &s.Spec.Template.Metadata
+	template.Annotations = mergeStringMaps(template.Annotations, options.TemplateAnnotations)
+	template.Labels = mergeStringMaps(template.Labels, options.TemplateLabels)
+}
+
+// SealOptions are the settings applied to every secret that is sealed
+type SealOptions struct {
+	Scope               string
+	Annotations         map[string]string
+	Labels              map[string]string
+	TemplateAnnotations map[string]string
+	TemplateLabels      map[string]string
+	// PassThrough copies the documents that are not Secrets to the output instead of rejecting them
+	PassThrough bool
+}
+
+// sealSecretDocuments seals every Secret in the yaml documents of the file and returns the
+// documents in the same order. A json file is written back as json.
+func sealSecretDocuments(data []byte, jsonFormat bool, publicKey *rsa.PublicKey, options SealOptions) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+
+	documents := [][]byte{}
+	for index := 1; ; index++ {
+		document := yaml.Node{}
+
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document %d: %v", index, err)
+		}
+
+		if isEmptyDocument(&document) {
+			continue
+		}
+
+		result, err := sealSecretDocument(&document, jsonFormat, publicKey, options)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %v", index, err)
+		}
+
+		documents = append(documents, result)
+	}
+
+	if len(documents) == 0 {
+		return nil, errors.New("no documents found")
+	}
+
+	if jsonFormat {
+		return bytes.Join(documents, []byte("\n")), nil
+	}
+
+	return bytes.Join(documents, []byte("---\n")), nil
+}
+
+func sealSecretDocument(document *yaml.Node, jsonFormat bool, publicKey *rsa.PublicKey, options SealOptions) ([]byte, error) {
+	secret := KubernetesSecret{}
+
+	err := document.Decode(&secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse secret: %v", err)
+	}
+
+	if secret.Kind != "Secret" {
+		if !options.PassThrough {
+			return nil, fmt.Errorf("expected a Secret but found kind %q", secret.Kind)
+		}
+
+		return marshalDocument(document, jsonFormat)
+	}
+
+	sealedSecret, err := sealSecret(secret, publicKey, options.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	sealedSecret.addMetadata(options)
+
+	return marshalDocument(sealedSecret, jsonFormat)
+}
+
+func isEmptyDocument(document *yaml.Node) bool {
+	if len(document.Content) == 0 {
+		return true
+	}
+
+	content := document.Content[0]
+
+	return content.Kind == yaml.ScalarNode && content.Tag == "!!null"
+}
+
+// marshalDocument writes the document with the two space indent used by kubeseal
+func marshalDocument(document interface{}, jsonFormat bool) ([]byte, error) {
+	if jsonFormat {
+		if node, ok := document.(*yaml.Node); ok {
+			var value interface{}
+
+			err := node.Decode(&value)
+			if err != nil {
+				return nil, err
+			}
+
+			document = value
+		}
+
+		result, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return append(result, '\n'), nil
+	}
+
+	var result bytes.Buffer
+
+	encoder := yaml.NewEncoder(&result)
+	encoder.SetIndent(2)
+
+	err := encoder.Encode(document)
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Bytes(), nil
+}
+
+// mergeStringMaps returns a copy of the values with the additions applied
+func mergeStringMaps(values map[string]string, additions map[string]string) map[string]string {
+	if len(additions) == 0 {
+		return values
+	}
+
+	result := copyStringMap(values)
+	if result == nil {
+		result = map[string]string{}
+	}
+
+	for key, value := range additions {
+		result[key] = value
+	}
+
+	return result
+}
+
+func copyStringMap(values map[string]string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(values))
+	for key, value := range values {
+		result[key] = value
+	}
+
+	return result
+}
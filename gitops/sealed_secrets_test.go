@@ -0,0 +1,137 @@
+package gitops
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	b64 "encoding/base64"
+	"encoding/binary"
+	"testing"
+)
+
+// hybridDecrypt reverses hybridEncrypt the way the sealed-secrets controller unseals a value
+func hybridDecrypt(t *testing.T, privateKey *rsa.PrivateKey, ciphertext []byte, label []byte) ([]byte, error) {
+	t.Helper()
+
+	if len(ciphertext) < 2 {
+		t.Fatal("the ciphertext is too short")
+	}
+
+	rsaLength := int(binary.BigEndian.Uint16(ciphertext))
+	if len(ciphertext) < 2+rsaLength {
+		t.Fatal("the ciphertext is shorter than the RSA ciphertext length")
+	}
+
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, ciphertext[2:2+rsaLength], label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zeroNonce := make([]byte, aead.NonceSize())
+
+	return aead.Open(nil, zeroNonce, ciphertext[2+rsaLength:], nil)
+}
+
+func TestSealSecretRoundTrip(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		scope         string
+		label         []byte
+		otherLabel    []byte
+		annotationKey string
+	}{
+		{scope: sealedSecretScopeStrict, label: []byte("my-namespace/db-credentials"), otherLabel: []byte("my-namespace/other")},
+		{scope: sealedSecretScopeNamespaceWide, label: []byte("my-namespace"), otherLabel: []byte("other-namespace"), annotationKey: namespaceWideAnnotation},
+		{scope: sealedSecretScopeClusterWide, label: nil, otherLabel: []byte("my-namespace"), annotationKey: clusterWideAnnotation},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scope, func(t *testing.T) {
+			label := sealedSecretLabel("my-namespace", "db-credentials", test.scope)
+			if !bytes.Equal(label, test.label) {
+				t.Fatalf("expected label %q, got %q", test.label, label)
+			}
+
+			secret := KubernetesSecret{
+				ApiVersion: "v1",
+				Kind:       "Secret",
+				Metadata:   SecretMetadata{Name: "db-credentials", Namespace: "my-namespace"},
+				Type:       "Opaque",
+				Data:       map[string]string{"username": b64.StdEncoding.EncodeToString([]byte("db-user"))},
+				StringData: map[string]string{"password": "db-password"},
+			}
+
+			sealedSecret, err := sealSecret(secret, &privateKey.PublicKey, test.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(test.annotationKey) > 0 && sealedSecret.Metadata.Annotations[test.annotationKey] != "true" {
+				t.Errorf("expected the %s annotation, got %v", test.annotationKey, sealedSecret.Metadata.Annotations)
+			}
+
+			expected := map[string]string{"username": "db-user", "password": "db-password"}
+			if len(sealedSecret.Spec.EncryptedData) != len(expected) {
+				t.Fatalf("expected %d encrypted values, got %v", len(expected), sealedSecret.Spec.EncryptedData)
+			}
+
+			for key, value := range expected {
+				ciphertext, err := b64.StdEncoding.DecodeString(sealedSecret.Spec.EncryptedData[key])
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				plaintext, err := hybridDecrypt(t, privateKey, ciphertext, test.label)
+				if err != nil {
+					t.Fatalf("unable to unseal %s: %v", key, err)
+				}
+				if string(plaintext) != value {
+					t.Errorf("expected %s=%q, got %q", key, value, plaintext)
+				}
+
+				// the value can only be unsealed for the name and namespace the scope binds it to
+				if _, err := hybridDecrypt(t, privateKey, ciphertext, test.otherLabel); err == nil {
+					t.Errorf("%s was unsealed with label %q", key, test.otherLabel)
+				}
+			}
+		})
+	}
+}
+
+func TestHybridEncryptUsesFreshSessionKeys(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := hybridEncrypt(rand.Reader, &privateKey.PublicKey, []byte("value"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := hybridEncrypt(rand.Reader, &privateKey.PublicKey, []byte("value"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(first, second) {
+		t.Error("sealing the same value twice produced the same ciphertext")
+	}
+}
@@ -0,0 +1,268 @@
+package gitops
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// secretFileExtensions are the extensions of the files read from the source dir
+var secretFileExtensions = map[string]bool{
+	".yaml": true,
+	".yml":  true,
+	".json": true,
+}
+
+// FileFilter selects files by their path relative to the source dir. A pattern without a slash
+// matches the file name in any directory and ** matches any number of directories.
+type FileFilter struct {
+	Include []string
+	Exclude []string
+}
+
+func isSecretFile(fileName string) bool {
+	return secretFileExtensions[strings.ToLower(filepath.Ext(fileName))]
+}
+
+func isJsonFile(fileName string) bool {
+	return strings.ToLower(filepath.Ext(fileName)) == ".json"
+}
+
+// listSecretFiles walks the source dir and returns the slash separated paths, relative to the
+// source dir, of the yaml and json files selected by the filter
+func listSecretFiles(sourceDir string, filter FileFilter) ([]string, error) {
+	include, err := compileGlobs(filter.Include)
+	if err != nil {
+		return nil, err
+	}
+
+	exclude, err := compileGlobs(filter.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	err = filepath.WalkDir(sourceDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if entry.IsDir() || !isSecretFile(entry.Name()) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if len(include) > 0 && !matchGlobs(include, relativePath) {
+			return nil
+		}
+
+		if matchGlobs(exclude, relativePath) {
+			return nil
+		}
+
+		result = append(result, relativePath)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func compileGlobs(patterns []string) ([]*regexp.Regexp, error) {
+	result := make([]*regexp.Regexp, 0, len(patterns))
+
+	for _, pattern := range patterns {
+		expression, err := globToRegexp(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+		}
+
+		result = append(result, expression)
+	}
+
+	return result, nil
+}
+
+func matchGlobs(globs []*regexp.Regexp, relativePath string) bool {
+	for _, glob := range globs {
+		if glob.MatchString(relativePath) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// globToRegexp converts the glob into a regular expression matched against the whole relative
+// path. * and ? do not match a slash, ** matches across directories and [...] is a character class.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimPrefix(filepath.ToSlash(pattern), "./")
+
+	var expression strings.Builder
+	expression.WriteString("^")
+
+	if !strings.Contains(pattern, "/") {
+		expression.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch char := pattern[i]; char {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**/") {
+				expression.WriteString("(?:.*/)?")
+				i += 2
+			} else if strings.HasPrefix(pattern[i:], "**") {
+				expression.WriteString(".*")
+				i++
+			} else {
+				expression.WriteString("[^/]*")
+			}
+		case '?':
+			expression.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in character class")
+			}
+
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			expression.WriteString("[" + class + "]")
+			i += end
+		default:
+			expression.WriteString(regexp.QuoteMeta(string(char)))
+		}
+	}
+
+	expression.WriteString("$")
+
+	return regexp.Compile(expression.String())
+}
+
+// sealSettingsDigest hashes the settings that change the sealed output of every file, so a new cert,
+// rotation trigger, scope or set of annotations or labels re-seals all the files
+func sealSettingsDigest(cert string, rotationTrigger string, options SealOptions) []byte {
+	values := []string{cert, rotationTrigger, options.Scope, strconv.FormatBool(options.PassThrough)}
+
+	for i, metadata := range []map[string]string{options.Annotations, options.Labels, options.TemplateAnnotations, options.TemplateLabels} {
+		values = append(values, strconv.Itoa(i))
+
+		for _, key := range sortedKeys(metadata) {
+			values = append(values, key+"="+metadata[key])
+		}
+	}
+
+	digest := sha256.New()
+	for _, value := range values {
+		digest.Write([]byte(value))
+		digest.Write([]byte{0})
+	}
+
+	return digest.Sum(nil)
+}
+
+// fileHashKeyBytes is the size of the random HMAC key generated for every seal secrets resource
+const fileHashKeyBytes = 32
+
+// newFileHashKey returns a random hex encoded key for sealedFileDigests
+func newFileHashKey() (string, error) {
+	key := make([]byte, fileHashKeyBytes)
+
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// sealedFileDigests returns the digest of each file, relative to the source dir, computed from the
+// contents of the file and the digest of the seal settings. The digest is an HMAC keyed with the
+// random key of the resource, so a digest in the state cannot be matched against a precomputed hash
+// of a guessed secret value.
+func sealedFileDigests(sourceDir string, files []string, hashKey string, settingsDigest []byte) (map[string]string, error) {
+	key, err := hex.DecodeString(hashKey)
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("the file hash key is missing or not hex encoded")
+	}
+
+	result := map[string]string{}
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(sourceDir, file))
+		if err != nil {
+			return nil, err
+		}
+
+		digest := hmac.New(sha256.New, key)
+		digest.Write(settingsDigest)
+		digest.Write(data)
+
+		result[file] = hex.EncodeToString(digest.Sum(nil))
+	}
+
+	return result, nil
+}
+
+// sealedOutputDigest returns the digest of the sealed files, relative to the dest dir. The sealed
+// output is encrypted with a new session key every time, so the digest reveals nothing about the
+// plaintext and changes whenever a file is sealed again.
+func sealedOutputDigest(destDir string, files []string) (string, error) {
+	digest := sha256.New()
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(destDir, file))
+		if err != nil {
+			return "", err
+		}
+
+		digest.Write([]byte(file))
+		digest.Write([]byte{0})
+		digest.Write(data)
+		digest.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// removeSealedFiles removes the files, relative to the dest dir, and the directories below the dest
+// dir that are left empty
+func removeSealedFiles(destDir string, files []string) error {
+	for _, file := range files {
+		destFile := filepath.Join(destDir, file)
+
+		err := os.Remove(destFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		for dir := filepath.Dir(destFile); dir != filepath.Clean(destDir) && dir != "." && dir != "/"; dir = filepath.Dir(dir) {
+			// fails, and stops the pruning, once the directory is not empty
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
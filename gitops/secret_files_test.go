@@ -0,0 +1,232 @@
+package gitops
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		matches bool
+	}{
+		{"*.yaml", "secret.yaml", true},
+		{"*.yaml", "dev/secret.yaml", true},
+		{"*.yaml", "secret.json", false},
+		{"dev/*.yaml", "dev/secret.yaml", true},
+		{"dev/*.yaml", "dev/db/secret.yaml", false},
+		{"dev/**/*.yaml", "dev/secret.yaml", true},
+		{"dev/**/*.yaml", "dev/db/primary/secret.yaml", true},
+		{"dev/**/*.yaml", "prod/secret.yaml", false},
+		{"**/db-*.yaml", "db-credentials.yaml", true},
+		{"**/db-*.yaml", "dev/db-credentials.yaml", true},
+		{"dev/**", "dev/db/secret.json", true},
+		{"secret-?.yaml", "secret-1.yaml", true},
+		{"secret-?.yaml", "secret-10.yaml", false},
+		{"dev?secret.yaml", "dev/secret.yaml", false},
+		{"secret-[0-9].yaml", "secret-7.yaml", true},
+		{"secret-[!0-9].yaml", "secret-7.yaml", false},
+		{"./dev/*.yaml", "dev/secret.yaml", true},
+		{"secret.v1.yaml", "secretXv1.yaml", false},
+	}
+
+	for _, test := range tests {
+		expression, err := globToRegexp(test.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", test.pattern, err)
+		}
+
+		if matches := expression.MatchString(test.path); matches != test.matches {
+			t.Errorf("expected %s to match %s: %v, got %v", test.pattern, test.path, test.matches, matches)
+		}
+	}
+
+	if _, err := globToRegexp("secret-[0-9.yaml"); err == nil {
+		t.Error("expected an error for an unterminated character class")
+	}
+}
+
+func TestListSecretFilesExcludeTakesPrecedence(t *testing.T) {
+	sourceDir := t.TempDir()
+
+	for _, file := range []string{"dev/db.yaml", "dev/api.json", "dev/notes.txt", "dev/test/db.yaml", "prod/db.yaml"} {
+		path := filepath.Join(sourceDir, filepath.FromSlash(file))
+
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = os.WriteFile(path, []byte("{}"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := listSecretFiles(sourceDir, FileFilter{
+		Include: []string{"dev/**"},
+		Exclude: []string{"**/test/**", "api.json"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"dev/db.yaml"}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestSealSecretDocumentsMultipleDocuments(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(`apiVersion: v1
+kind: Secret
+metadata:
+  name: first
+  namespace: my-namespace
+stringData:
+  password: first-password
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  level: debug
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: second
+  namespace: my-namespace
+stringData:
+  password: second-password
+`)
+
+	result, err := sealSecretDocuments(data, false, &privateKey.PublicKey, SealOptions{PassThrough: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(result), "first-password") || strings.Contains(string(result), "second-password") {
+		t.Fatalf("the sealed documents hold a password in plain text:\n%s", result)
+	}
+
+	decoder := yaml.NewDecoder(strings.NewReader(string(result)))
+
+	kinds := []string{}
+	names := []string{}
+	for {
+		document := map[string]interface{}{}
+		if decoder.Decode(&document) != nil {
+			break
+		}
+
+		kinds = append(kinds, document["kind"].(string))
+		names = append(names, document["metadata"].(map[string]interface{})["name"].(string))
+	}
+
+	// the empty document is dropped and the config map is copied as is between the sealed secrets
+	if expected := []string{sealedSecretKind, "ConfigMap", sealedSecretKind}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("expected kinds %v, got %v", expected, kinds)
+	}
+	if expected := []string{"first", "settings", "second"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected names %v, got %v", expected, names)
+	}
+
+	_, err = sealSecretDocuments(data, false, &privateKey.PublicKey, SealOptions{})
+	if err == nil || !strings.Contains(err.Error(), "document 3") {
+		t.Errorf("expected the config map to be rejected without pass through, got %v", err)
+	}
+}
+
+func TestSealSecretDocumentsJson(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte(`{
+  "apiVersion": "v1",
+  "kind": "Secret",
+  "metadata": {"name": "db-credentials", "namespace": "my-namespace"},
+  "type": "Opaque",
+  "data": {"password": "ZGItcGFzc3dvcmQ="}
+}`)
+
+	result, err := sealSecretDocuments(data, true, &privateKey.PublicKey, SealOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealedSecret SealedSecret
+	err = json.Unmarshal(result, &sealedSecret)
+	if err != nil {
+		t.Fatalf("expected json output: %v\n%s", err, result)
+	}
+
+	if sealedSecret.Kind != sealedSecretKind || sealedSecret.Metadata.Name != "db-credentials" || sealedSecret.Spec.Template.Type != "Opaque" {
+		t.Errorf("unexpected sealed secret %+v", sealedSecret)
+	}
+
+	if _, found := sealedSecret.Spec.EncryptedData["password"]; !found {
+		t.Error("expected password in the encrypted data")
+	}
+}
+
+func TestSealedFileDigestsAreKeyed(t *testing.T) {
+	sourceDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(sourceDir, "secret.yaml"), []byte("password: admin"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settingsDigest := sealSettingsDigest("cert", "", SealOptions{})
+
+	digests := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		hashKey, err := newFileHashKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		first, err := sealedFileDigests(sourceDir, []string{"secret.yaml"}, hashKey, settingsDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		second, err := sealedFileDigests(sourceDir, []string{"secret.yaml"}, hashKey, settingsDigest)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if first["secret.yaml"] != second["secret.yaml"] {
+			t.Error("the digest of the same file with the same key changed")
+		}
+
+		digests[first["secret.yaml"]] = true
+	}
+
+	// a digest copied from the state of another resource does not match
+	if len(digests) != 2 {
+		t.Error("resources with different keys produced the same digest")
+	}
+
+	if _, err := sealedFileDigests(sourceDir, []string{"secret.yaml"}, "", settingsDigest); err == nil {
+		t.Error("expected an error without a key")
+	}
+}
@@ -0,0 +1,131 @@
+package gitops
+
+import (
+	"context"
+	"fmt"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"time"
+)
+
+const defaultGitopsTimeout = 20 * time.Minute
+
+// gitopsResourceTimeouts declares the create, read, update and delete timeouts supported by the
+// resources. A timeout that is not set in the timeouts block of the resource falls back to the
+// timeouts block of the provider.
+func gitopsResourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Create:  schema.DefaultTimeout(defaultGitopsTimeout),
+		Read:    schema.DefaultTimeout(defaultGitopsTimeout),
+		Update:  schema.DefaultTimeout(defaultGitopsTimeout),
+		Delete:  schema.DefaultTimeout(defaultGitopsTimeout),
+		Default: schema.DefaultTimeout(defaultGitopsTimeout),
+	}
+}
+
+// gitopsDataSourceTimeouts declares the read timeout supported by the data sources
+func gitopsDataSourceTimeouts() *schema.ResourceTimeout {
+	return &schema.ResourceTimeout{
+		Read:    schema.DefaultTimeout(defaultGitopsTimeout),
+		Default: schema.DefaultTimeout(defaultGitopsTimeout),
+	}
+}
+
+// gitopsTimeout resolves the timeout of the operation. The timeouts block of the resource takes
+// precedence over the timeouts block of the provider.
+func gitopsTimeout(d *schema.ResourceData, config *ProviderConfig, key string) time.Duration {
+	timeout := d.Timeout(key)
+
+	if resourceTimeoutConfigured(d, key) {
+		return timeout
+	}
+
+	if providerTimeout, ok := config.Timeouts[key]; ok {
+		return providerTimeout
+	}
+
+	return timeout
+}
+
+// resourceTimeoutConfigured reports whether the timeouts block of the resource sets the timeout, so
+// a timeout explicitly set to the default still takes precedence. Terraform does not send the config
+// for every operation, e.g. on destroy, and then the timeouts block recorded in the state is used.
+// Only when neither holds the timeouts block is a timeout that differs from the default taken as set.
+func resourceTimeoutConfigured(d *schema.ResourceData, key string) bool {
+	for _, raw := range []cty.Value{d.GetRawConfig(), d.GetRawState()} {
+		if raw.IsNull() || !raw.IsKnown() || !raw.Type().IsObjectType() || !raw.Type().HasAttribute(schema.TimeoutsConfigKey) {
+			continue
+		}
+
+		timeouts := raw.GetAttr(schema.TimeoutsConfigKey)
+		if timeouts.IsNull() || !timeouts.IsKnown() || !timeouts.Type().HasAttribute(key) {
+			return false
+		}
+
+		return !timeouts.GetAttr(key).IsNull()
+	}
+
+	return d.Timeout(key) != defaultGitopsTimeout
+}
+
+// withGitopsTimeout bounds the context passed to the operation by the resolved timeout. It is used
+// in place of the CreateContext, ReadContext, etc. handlers, which only honor the resource timeouts.
+func withGitopsTimeout(key string, f func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics) func(context.Context, *schema.ResourceData, interface{}) diag.Diagnostics {
+	return func(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+		config := m.(*ProviderConfig)
+
+		ctx, cancel := context.WithTimeout(ctx, gitopsTimeout(d, config, key))
+		defer cancel()
+
+		return f(ctx, d, m)
+	}
+}
+
+// commandContextError describes why the command was stopped when the context ended before the
+// command completed
+func commandContextError(ctx context.Context, command string) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("command %q timed out", command)
+	case context.Canceled:
+		return fmt.Errorf("command %q was cancelled", command)
+	}
+
+	return nil
+}
+
+func validateDuration(value interface{}, key string) ([]string, []error) {
+	_, err := time.ParseDuration(value.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s must be a duration, e.g. 30s or 10m: %v", key, err)}
+	}
+
+	return nil, nil
+}
+
+func loadProviderTimeouts(d *schema.ResourceData) (map[string]time.Duration, error) {
+	result := map[string]time.Duration{}
+
+	rawTimeouts := d.Get("timeouts").([]interface{})
+	if len(rawTimeouts) == 0 || rawTimeouts[0] == nil {
+		return result, nil
+	}
+
+	values := rawTimeouts[0].(map[string]interface{})
+	for _, key := range []string{schema.TimeoutCreate, schema.TimeoutRead, schema.TimeoutUpdate, schema.TimeoutDelete} {
+		value, ok := values[key].(string)
+		if !ok || len(value) == 0 {
+			continue
+		}
+
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, err
+		}
+
+		result[key] = timeout
+	}
+
+	return result, nil
+}
@@ -0,0 +1,84 @@
+package gitops
+
+import (
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+	"testing"
+	"time"
+)
+
+// testTimeoutsValue returns the timeouts block with the create and delete timeouts, or a null
+// block when both are empty
+func testTimeoutsValue(create string, delete string) *cty.Value {
+	timeouts := cty.NullVal(cty.Object(map[string]cty.Type{"create": cty.String, "delete": cty.String}))
+	if len(create) == 0 && len(delete) == 0 {
+		return &timeouts
+	}
+
+	value := func(timeout string) cty.Value {
+		if len(timeout) == 0 {
+			return cty.NullVal(cty.String)
+		}
+		return cty.StringVal(timeout)
+	}
+
+	timeouts = cty.ObjectVal(map[string]cty.Value{"create": value(create), "delete": value(delete)})
+
+	return &timeouts
+}
+
+func TestGitopsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// rawConfig and rawState are the timeouts blocks sent by terraform, nil when terraform does
+		// not send the config or the state
+		rawConfig       *cty.Value
+		rawState        *cty.Value
+		resourceTimeout time.Duration
+		providerTimeout time.Duration
+		expected        time.Duration
+	}{
+		{name: "resource timeout", rawConfig: testTimeoutsValue("", "10m"), resourceTimeout: 10 * time.Minute, providerTimeout: 5 * time.Minute, expected: 10 * time.Minute},
+		{name: "resource timeout set to the default", rawConfig: testTimeoutsValue("", "20m"), resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: defaultGitopsTimeout},
+		{name: "other resource timeout", rawConfig: testTimeoutsValue("10m", ""), resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: 5 * time.Minute},
+		{name: "no timeouts block", rawConfig: testTimeoutsValue("", ""), resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: 5 * time.Minute},
+		{name: "no provider timeout", rawConfig: testTimeoutsValue("", ""), resourceTimeout: defaultGitopsTimeout, expected: defaultGitopsTimeout},
+		{name: "destroy with the resource timeout set to the default", rawState: testTimeoutsValue("", "20m"), resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: defaultGitopsTimeout},
+		{name: "destroy without a timeouts block", rawState: testTimeoutsValue("", ""), resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: 5 * time.Minute},
+		{name: "no raw values with a resource timeout", resourceTimeout: 30 * time.Minute, providerTimeout: 5 * time.Minute, expected: 30 * time.Minute},
+		{name: "no raw values", resourceTimeout: defaultGitopsTimeout, providerTimeout: 5 * time.Minute, expected: 5 * time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// the sdk decodes the timeouts into the resource timeouts before the operation runs
+			resource := &schema.Resource{
+				Schema: map[string]*schema.Schema{
+					"name": {Type: schema.TypeString, Optional: true},
+				},
+				Timeouts: &schema.ResourceTimeout{
+					Delete:  schema.DefaultTimeout(test.resourceTimeout),
+					Default: schema.DefaultTimeout(defaultGitopsTimeout),
+				},
+			}
+
+			state := &terraform.InstanceState{ID: "my-module"}
+			if test.rawConfig != nil {
+				state.RawConfig = cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal("my-module"), schema.TimeoutsConfigKey: *test.rawConfig})
+			}
+			if test.rawState != nil {
+				state.RawState = cty.ObjectVal(map[string]cty.Value{"id": cty.StringVal("my-module"), schema.TimeoutsConfigKey: *test.rawState})
+			}
+
+			config := &ProviderConfig{Timeouts: map[string]time.Duration{}}
+			if test.providerTimeout > 0 {
+				config.Timeouts[schema.TimeoutDelete] = test.providerTimeout
+			}
+
+			if timeout := gitopsTimeout(resource.Data(state), config, schema.TimeoutDelete); timeout != test.expected {
+				t.Errorf("expected %s, got %s", test.expected, timeout)
+			}
+		})
+	}
+}